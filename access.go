@@ -0,0 +1,216 @@
+package weather
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/database"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/service"
+)
+
+// 保存在数据库中的名单
+const (
+	listBlacklist = "blacklist"
+	listWhitelist = "whitelist"
+	listAllowed   = "allowed"
+	listAdmins    = "admins"
+)
+
+// DefaultAuditCount 「.weather.audit」默认显示的记录数量
+const DefaultAuditCount int = 10
+
+// MaxAuditCount 「.weather.audit」最多显示的记录数量
+const MaxAuditCount int = 50
+
+// accessLists 名单缓存，启动时从数据库加载，修改名单时同步更新
+var accessLists = struct {
+	sync.RWMutex
+	ids  map[string][]int64
+	sets map[string]map[int64]bool
+}{}
+
+// accessListUpdates 修改名单时依次进行，保证重新读取的名单不会覆盖之后的修改
+var accessListUpdates sync.Mutex
+
+// setAccessLists 替换名单缓存
+func setAccessLists(lists map[string][]int64) {
+	sets := make(map[string]map[int64]bool, len(lists))
+	for name, ids := range lists {
+		sets[name] = make(map[int64]bool, len(ids))
+		for _, id := range ids {
+			sets[name][id] = true
+		}
+	}
+	accessLists.Lock()
+	defer accessLists.Unlock()
+	accessLists.ids, accessLists.sets = lists, sets
+}
+
+// inList 号码是否在名单中
+func inList(list string, id int64) bool {
+	accessLists.RLock()
+	defer accessLists.RUnlock()
+	return accessLists.sets[list][id]
+}
+
+// listIDs 名单中的全部号码，按添加顺序排列
+func listIDs(list string) []int64 {
+	accessLists.RLock()
+	defer accessLists.RUnlock()
+	return append([]int64(nil), accessLists.ids[list]...)
+}
+
+// configAccessLists 配置文件中的名单
+func configAccessLists() map[string][]int64 {
+	c := currentConfig()
+	return map[string][]int64{
+		listBlacklist: c.BlackList,
+		listWhitelist: c.WhiteList,
+		listAllowed:   c.Allowed,
+		listAdmins:    c.Admin,
+	}
+}
+
+// initAccessLists 加载名单
+// 首次运行（还没有任何修改记录）时把配置文件中的名单导入数据库，之后以数据库为准
+// 配置文件中的名单保留原样，只在日志中提示已不再生效，不会改写配置文件
+// 读取数据库失败时使用配置文件中的名单
+func initAccessLists() {
+	dbService := service.NewDBService(database.GetDB())
+	count, err := dbService.CountAuditLogs()
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to count audit logs.")
+	}
+	lists := configAccessLists()
+	imported := 0
+	for _, ids := range lists {
+		imported += len(ids)
+	}
+	if err == nil && count == 0 && imported > 0 {
+		if err := dbService.ImportAccessLists(lists, "从配置文件导入"); err != nil {
+			logger.WithError(err).Errorf("Fail to import access lists from config file.")
+		} else {
+			logger.Infof("Import %d access list entries from config file.", imported)
+			logger.Warnf("Access lists in config file are now ignored, use admin commands to change them. They can be removed from the config file.")
+		}
+	} else if count > 0 && imported > 0 {
+		logger.Warnf("Access lists in config file are ignored, they have been imported into the database before.")
+	}
+	stored, err := dbService.GetAccessLists()
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to load access lists, fallback to config file.")
+		stored = lists
+	}
+	setAccessLists(stored)
+}
+
+// updateAccessList 批量添加或移除名单中的号码并记录操作者和原因
+// kind 为「用户」或「群」，listName 为名单名称
+func updateAccessList(ctx *commandContext, list string, ids []int64, add bool, kind, listName string) string {
+	reason := ctx.str("原因")
+	accessListUpdates.Lock()
+	defer accessListUpdates.Unlock()
+	dbService := service.NewDBService(database.GetDB())
+	changed, err := dbService.UpdateAccessList(list, ids, add, ctx.sender.Uin, reason)
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to update access list.")
+		return DatabaseErrorMessage
+	}
+	if len(changed) > 0 {
+		stored, err := dbService.GetAccessLists()
+		if err != nil {
+			logger.WithError(err).Errorf("Fail to load access lists.")
+			return DatabaseErrorMessage
+		}
+		setAccessLists(stored)
+	}
+	changedSet := make(map[int64]bool, len(changed))
+	for _, id := range changed {
+		changedSet[id] = true
+	}
+	var skipped []int64
+	for _, id := range ids {
+		if !changedSet[id] {
+			skipped = append(skipped, id)
+		}
+	}
+	var lines []string
+	switch {
+	case len(changed) > 0 && add:
+		lines = append(lines, fmt.Sprintf("成功添加%s%s到%s。", kind, quoteIDs(changed), listName))
+	case len(changed) > 0:
+		lines = append(lines, fmt.Sprintf("成功将%s%s从%s中移除。", kind, quoteIDs(changed), listName))
+	}
+	switch {
+	case len(skipped) > 0 && add:
+		lines = append(lines, fmt.Sprintf("%s%s已在%s中。", kind, quoteIDs(skipped), listName))
+	case len(skipped) > 0:
+		lines = append(lines, fmt.Sprintf("%s%s不在%s中。", kind, quoteIDs(skipped), listName))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// removeAdmins 移除管理员，不能移除全部管理员
+func removeAdmins(ctx *commandContext, uins []int64) string {
+	remaining := 0
+	for _, admin := range listIDs(listAdmins) {
+		removed := false
+		for _, uin := range uins {
+			removed = removed || uin == admin
+		}
+		if !removed {
+			remaining++
+		}
+	}
+	if remaining == 0 {
+		return "至少需要保留一个管理员。"
+	}
+	return updateAccessList(ctx, listAdmins, uins, false, "用户", "管理员")
+}
+
+// auditActions 修改记录的操作名称
+var auditActions = map[string]string{
+	"add":    "添加到",
+	"remove": "移出",
+	"import": "导入到",
+}
+
+// showAudit 查看最近的名单修改记录
+func showAudit(count int) string {
+	if count <= 0 {
+		count = DefaultAuditCount
+	}
+	if count > MaxAuditCount {
+		count = MaxAuditCount
+	}
+	logs, err := service.NewDBService(database.GetDB()).GetAuditLogs(count)
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to get audit logs.")
+		return DatabaseErrorMessage
+	}
+	if len(logs) == 0 {
+		return "还没有名单修改记录。"
+	}
+	lines := []string{fmt.Sprintf("最近 %d 条名单修改记录：", len(logs))}
+	for _, log := range logs {
+		actor := fmt.Sprint(log.Actor)
+		if log.Actor == 0 {
+			actor = "配置文件"
+		}
+		action, ok := auditActions[log.Action]
+		if !ok {
+			action = log.Action
+		}
+		listName, ok := listNames[log.ListName]
+		if !ok {
+			listName = log.ListName
+		}
+		line := fmt.Sprintf("%s %s 将「%d」%s%s", log.CreatedAt.Format(timeLayout), actor, log.Target, action, listName)
+		if log.Reason != "" {
+			line += "，原因：" + log.Reason
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,423 @@
+package weather
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Mrs4s/MiraiGo/message"
+)
+
+// scope 指令可用的场景
+type scope int
+
+const (
+	scopeGroup scope = 1 << iota
+	scopePrivate
+	scopeGuild // 频道的子频道
+
+	scopeAll = scopeGroup | scopePrivate | scopeGuild
+)
+
+// role 指令需要的权限
+type role int
+
+const (
+	roleUser       role = iota // 所有用户，群聊和频道中仅在许可名单内的群和子频道可用
+	roleAdmin                  // 模块管理员
+	roleGroupAdmin             // 模块管理员，或者许可名单内的群的群主和管理员
+)
+
+// argKind 参数类型
+type argKind int
+
+const (
+	argString argKind = iota // 单个词
+	argInt                   // 正整数
+	argUin                   // QQ 号或群号
+	argUins                  // 剩余的全部 QQ 号或群号，至少一个；支持 @ 群成员，后面可以跟一个 argRest 参数
+	argRest                  // 剩余的全部文本
+)
+
+// argSpec 参数定义
+type argSpec struct {
+	name        string
+	kind        argKind
+	optional    bool
+	description string // 参数说明，用于帮助信息
+}
+
+// command 指令定义
+type command struct {
+	name        string   // 指令名称，同时也是默认的主触发词和配置文件中的键
+	aliases     []string // 默认的其他触发词
+	scopes      scope
+	role        role
+	args        []argSpec
+	description string   // 一句话说明，用于帮助信息
+	examples    []string // 示例，用于帮助信息
+	feature     string   // 对应的群功能，为空时不受群设置限制
+	cost        int      // 每次查询消耗的调用次数，为 0 时为 DefaultCost
+	handler     func(ctx *commandContext) string
+}
+
+// commandContext 指令上下文
+type commandContext struct {
+	scope      scope
+	sender     *message.Sender
+	groupCode  int64  // 仅群聊
+	groupAdmin bool   // 仅群聊，发送者是否为群主或管理员
+	guildID    uint64 // 仅频道
+	channelID  uint64 // 仅频道
+	cost       int    // 本次查询消耗的调用次数
+	args       map[string]interface{}
+
+	topic     string                // 仅群聊，去重时区分同一标题下的不同问题，见 questionTopic
+	answerKey string                // 仅群聊，本次回答的去重键，发送成功后记录
+	repeatOf  *message.GroupMessage // 仅群聊，重复查询时引用的之前的回答
+}
+
+// str 获取字符串参数，缺省时返回空字符串
+func (ctx *commandContext) str(name string) string {
+	v, _ := ctx.args[name].(string)
+	return v
+}
+
+// uin 获取 QQ 号参数，缺省时返回 0
+func (ctx *commandContext) uin(name string) int64 {
+	v, _ := ctx.args[name].(int64)
+	return v
+}
+
+// num 获取整数参数，缺省时返回 0
+func (ctx *commandContext) num(name string) int {
+	v, _ := ctx.args[name].(int)
+	return v
+}
+
+// uins 获取多个 QQ 号参数，缺省时返回 nil
+func (ctx *commandContext) uins(name string) []int64 {
+	v, _ := ctx.args[name].([]int64)
+	return v
+}
+
+// commandTrigger 触发词与对应的指令
+type commandTrigger struct {
+	word string
+	cmd  *command
+}
+
+// triggerTable 生效的触发词，创建后不再修改，重新设置时整体替换，可以在多个协程中同时读取
+type triggerTable struct {
+	prefix   string                // 管理员指令前缀
+	triggers []commandTrigger      // 按长度从长到短排列，保证优先匹配更具体的触发词
+	words    map[*command][]string // 每个指令的触发词，第一个为主触发词
+}
+
+// commands 已注册的指令，按注册顺序排列
+var commands []*command
+
+// activeTriggers 生效的触发词
+var activeTriggers atomic.Pointer[triggerTable]
+
+// defaultPrefix 管理员指令的默认前缀
+const defaultPrefix string = ".weather."
+
+// currentTriggers 当前生效的触发词
+func currentTriggers() *triggerTable {
+	return activeTriggers.Load()
+}
+
+// registerCommand 注册指令，使用默认触发词
+func registerCommand(cmd *command) {
+	commands = append(commands, cmd)
+	t, err := buildTriggers(defaultPrefix, nil)
+	if err != nil {
+		panic(err)
+	}
+	activeTriggers.Store(t)
+}
+
+// defaultTriggers 默认触发词，以默认前缀开头的触发词替换为 prefix
+func (cmd *command) defaultTriggers(prefix string) []string {
+	words := append([]string{cmd.name}, cmd.aliases...)
+	triggers := make([]string, len(words))
+	for i, word := range words {
+		if strings.HasPrefix(word, defaultPrefix) {
+			word = prefix + strings.TrimPrefix(word, defaultPrefix)
+		}
+		triggers[i] = word
+	}
+	return triggers
+}
+
+// buildTriggers 按配置创建触发词表
+// prefix 为管理员指令前缀，为空时使用默认前缀；custom 的键为指令名称，值为替换默认触发词的自定义触发词
+func buildTriggers(prefix string, custom map[string][]string) (*triggerTable, error) {
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	for name := range custom {
+		if findCommand(name) == nil {
+			return nil, fmt.Errorf("unknown command %q", name)
+		}
+	}
+	t := &triggerTable{prefix: prefix, words: make(map[*command][]string, len(commands))}
+	seen := make(map[string]bool)
+	for _, cmd := range commands {
+		words, ok := custom[cmd.name]
+		if !ok {
+			words = cmd.defaultTriggers(prefix)
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("command %q has no trigger", cmd.name)
+		}
+		for _, word := range words {
+			if strings.TrimSpace(word) != word || word == "" {
+				return nil, fmt.Errorf("invalid trigger %q for command %q", word, cmd.name)
+			}
+			if seen[word] {
+				return nil, fmt.Errorf("duplicate command trigger %q", word)
+			}
+			seen[word] = true
+			t.triggers = append(t.triggers, commandTrigger{word: word, cmd: cmd})
+		}
+		t.words[cmd] = append([]string(nil), words...)
+	}
+	sort.SliceStable(t.triggers, func(i, j int) bool {
+		return len(t.triggers[i].word) > len(t.triggers[j].word)
+	})
+	return t, nil
+}
+
+// configureTriggers 按配置重新设置全部触发词
+// 配置有误时返回错误，此时触发词恢复为默认值
+func configureTriggers(prefix string, custom map[string][]string) error {
+	t, err := buildTriggers(prefix, custom)
+	if err != nil {
+		t, _ = buildTriggers(defaultPrefix, nil)
+	}
+	activeTriggers.Store(t)
+	return err
+}
+
+// checkTriggers 检查触发词配置，不修改当前生效的触发词
+func checkTriggers(prefix string, custom map[string][]string) error {
+	_, err := buildTriggers(prefix, custom)
+	return err
+}
+
+// findCommand 按指令名称查找指令
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// trigger 指令的主触发词
+func (cmd *command) trigger() string {
+	return currentTriggers().words[cmd][0]
+}
+
+// triggerOf 按指令名称获取主触发词，用于提示信息
+func triggerOf(name string) string {
+	if cmd := findCommand(name); cmd != nil {
+		return cmd.trigger()
+	}
+	return name
+}
+
+// matchCommand 查找消息对应的指令，返回指令和触发词之后的参数文本
+// 触发词需完整匹配；有参数的指令也可以在触发词后紧跟空格和参数
+func matchCommand(msg string) (*command, string) {
+	for _, t := range currentTriggers().triggers {
+		if msg == t.word {
+			return t.cmd, ""
+		}
+		if len(t.cmd.args) > 0 && strings.HasPrefix(msg, t.word+" ") {
+			return t.cmd, strings.TrimSpace(msg[len(t.word):])
+		}
+	}
+	return nil, ""
+}
+
+// dispatch 分发指令，返回回复内容，不需要回复时返回空字符串
+func dispatch(ctx *commandContext, msg string) string {
+	cmd, rest := matchCommand(msg)
+	if cmd == nil {
+		if isAdmin(ctx.sender.Uin) && strings.HasPrefix(msg, currentTriggers().prefix) {
+			return unknownAdminCommand(ctx, msg)
+		}
+		return answerQuestion(ctx, msg)
+	}
+	if !cmd.available(ctx) {
+		return ""
+	}
+	args, errMsg := parseArgs(cmd, rest)
+	if errMsg != "" {
+		return errMsg
+	}
+	ctx.args = args
+	ctx.cost = cmd.cost
+	return cmd.handler(ctx)
+}
+
+// available 当前场景和用户是否可以使用指令
+func (cmd *command) available(ctx *commandContext) bool {
+	if cmd.scopes&ctx.scope == 0 {
+		return false
+	}
+	// 群内关闭的功能
+	if ctx.scope == scopeGroup && cmd.feature != "" && !groupFeatureEnabled(ctx.groupCode, cmd.feature) {
+		return false
+	}
+	switch cmd.role {
+	case roleAdmin:
+		return isAdmin(ctx.sender.Uin)
+	case roleGroupAdmin:
+		return isAdmin(ctx.sender.Uin) || (ctx.scope == scopeGroup && ctx.groupAdmin && isAllowedGroup(ctx.groupCode))
+	case roleUser:
+		// 忽略未开启功能的群组和子频道
+		switch ctx.scope {
+		case scopeGroup:
+			return isAllowedGroup(ctx.groupCode)
+		case scopeGuild:
+			return isAllowedChannel(ctx.guildID, ctx.channelID)
+		}
+		return true
+	}
+	return false
+}
+
+// unknownAdminCommand 管理员发送了无法识别的管理员指令时的提示
+// 触发词正确但多了参数时提示正确的用法
+func unknownAdminCommand(ctx *commandContext, msg string) string {
+	for _, t := range currentTriggers().triggers {
+		if strings.HasPrefix(msg, t.word+" ") && t.cmd.available(ctx) {
+			return usageError(t.cmd)
+		}
+	}
+	return fmt.Sprintf("未知指令「%s」，发送「%s」查看全部指令。", strings.Fields(msg)[0], triggerOf(".weather.help"))
+}
+
+// parseArgs 按参数定义解析参数，解析失败时返回错误提示
+func parseArgs(cmd *command, rest string) (map[string]interface{}, string) {
+	args := make(map[string]interface{}, len(cmd.args))
+	fields := strings.Fields(rest)
+	for i, spec := range cmd.args {
+		if spec.kind == argUins {
+			if i >= len(fields) {
+				if spec.optional {
+					break
+				}
+				return nil, usageError(cmd)
+			}
+			// 后面跟着 argRest 参数时，第一个不是号码的词开始为该参数
+			hasRest := i+1 < len(cmd.args) && cmd.args[i+1].kind == argRest
+			uins := make([]int64, 0, len(fields)-i)
+			j := i
+			for ; j < len(fields); j++ {
+				uin, err := parseUin(fields[j])
+				if err != nil {
+					if hasRest && (j > i || spec.optional) {
+						break
+					}
+					return nil, fmt.Sprintf("解析失败，「%s」不是正确的 uin。", fields[j])
+				}
+				uins = append(uins, uin)
+			}
+			if len(uins) > 0 {
+				args[spec.name] = uins
+			}
+			if j < len(fields) {
+				args[cmd.args[i+1].name] = skipFields(rest, j)
+			}
+			fields = nil
+			break
+		}
+		if spec.kind == argRest {
+			value := skipFields(rest, i)
+			if value == "" && !spec.optional {
+				return nil, usageError(cmd)
+			}
+			args[spec.name] = value
+			fields = nil
+			break
+		}
+		if i >= len(fields) {
+			if spec.optional {
+				break
+			}
+			return nil, usageError(cmd)
+		}
+		switch spec.kind {
+		case argInt:
+			n, err := strconv.Atoi(fields[i])
+			if err != nil || n <= 0 {
+				return nil, fmt.Sprintf("解析失败，「%s」不是正确的数字。", fields[i])
+			}
+			args[spec.name] = n
+		case argUin:
+			uin, err := parseUin(fields[i])
+			if err != nil {
+				return nil, fmt.Sprintf("解析失败，「%s」不是正确的 uin。", fields[i])
+			}
+			args[spec.name] = uin
+		default:
+			args[spec.name] = fields[i]
+		}
+	}
+	if len(fields) > len(cmd.args) {
+		return nil, usageError(cmd)
+	}
+	return args, ""
+}
+
+// parseUin 解析 QQ 号或群号，允许带 @ 前缀（群聊中 @ 成员会转换为「@QQ 号」）
+func parseUin(s string) (int64, error) {
+	uin, err := strconv.ParseInt(strings.TrimPrefix(s, "@"), 10, 64)
+	if err == nil && uin <= 0 {
+		err = fmt.Errorf("invalid uin %d", uin)
+	}
+	return uin, err
+}
+
+// skipFields 跳过前 n 个词，返回剩余的原始文本
+func skipFields(s string, n int) string {
+	s = strings.TrimSpace(s)
+	for i := 0; i < n && s != ""; i++ {
+		if index := strings.IndexAny(s, " \t\n"); index >= 0 {
+			s = strings.TrimSpace(s[index:])
+		} else {
+			s = ""
+		}
+	}
+	return s
+}
+
+// usage 指令用法，如「.weather.clear.times <uin>」
+func (cmd *command) usage() string {
+	usage := cmd.trigger()
+	for _, spec := range cmd.args {
+		name := spec.name
+		if spec.kind == argUins {
+			name += "..."
+		}
+		if spec.optional {
+			usage += " [" + name + "]"
+		} else {
+			usage += " <" + name + ">"
+		}
+	}
+	return usage
+}
+
+// usageError 参数错误提示
+func usageError(cmd *command) string {
+	return fmt.Sprintf("参数错误，正确的格式：「%s」。", cmd.usage())
+}
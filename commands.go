@@ -0,0 +1,346 @@
+package weather
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/service"
+)
+
+// reasonArg 修改名单时记录的原因
+var reasonArg = argSpec{name: "原因", kind: argRest, optional: true, description: "记录在修改记录中，号码之后的文字均为原因"}
+
+// userListArgs 修改用户名单的参数
+var userListArgs = []argSpec{
+	{name: "uin", kind: argUins, description: "一个或多个用户 QQ 号，群聊中也可以直接 @ 用户"},
+	reasonArg,
+}
+
+// init 注册全部指令
+// 新增指令只需要在这里注册一次
+func init() {
+	registerCommand(&command{
+		name:        "修改地址",
+		scopes:      scopeAll,
+		role:        roleUser,
+		args:        []argSpec{{name: "坐标", kind: argRest, description: "经度 纬度，也支持「纬度, 经度」、度分秒和 N/S/E/W 标记；高德、腾讯地图的坐标在末尾加上「高德」，百度地图加上「百度」"}},
+		description: "保存查询天气使用的地址",
+		examples:    []string{"修改地址 116.4074 39.9042", "修改地址 39°54'15\"N 116°24'27\"E", "修改地址 116.4133,39.9110 高德"},
+		handler:     func(ctx *commandContext) string { return updateLocation(ctx.sender, ctx.str("坐标")) },
+	})
+	registerCommand(&command{
+		name:        "设置时区",
+		scopes:      scopeAll,
+		role:        roleUser,
+		args:        []argSpec{{name: "时区", kind: argString, description: "IANA 时区名称，「当地」表示使用预报地点的时区，「北京时间」等同于 Asia/Shanghai"}},
+		description: "设置显示时间使用的时区",
+		examples:    []string{"设置时区 Asia/Shanghai", "设置时区 当地"},
+		handler:     func(ctx *commandContext) string { return updateTimezone(ctx.sender.Uin, ctx.str("时区")) },
+	})
+	registerCommand(&command{
+		name:        "我的天气设置",
+		scopes:      scopeAll,
+		role:        roleUser,
+		description: "查看保存的地址、时区、等级和今日剩余次数，不消耗调用次数",
+		handler:     showProfile,
+	})
+	registerCommand(&command{
+		name:        "天气帮助",
+		scopes:      scopeAll,
+		role:        roleUser,
+		args:        []argSpec{{name: "指令", kind: argRest, optional: true, description: "查看单个指令的详细用法"}},
+		description: "列出可用的指令",
+		examples:    []string{"天气帮助", "天气帮助 修改地址"},
+		handler:     func(ctx *commandContext) string { return helpMessage(ctx, ctx.str("指令")) },
+	})
+	registerCommand(&command{
+		name:        "实时天气",
+		scopes:      scopeAll,
+		role:        roleUser,
+		description: "查询保存地址的实时天气",
+		feature:     featureRealtime,
+		handler:     weatherHandler("实时天气", (*service.Caiyun).RealTime),
+	})
+	registerCommand(&command{
+		name:        "出门建议",
+		scopes:      scopeAll,
+		role:        roleUser,
+		description: "查询未来两小时是否有雨",
+		feature:     featureNowcast,
+		handler:     weatherHandler("出门建议", (*service.Caiyun).Rain),
+	})
+	registerCommand(&command{
+		name:        "今天天气",
+		scopes:      scopeAll,
+		role:        roleUser,
+		description: "查询保存地址今天的天气预报",
+		feature:     featureDaily,
+		cost:        DailyCost,
+		handler:     weatherHandler("今天天气", (*service.Caiyun).Today),
+	})
+	registerCommand(&command{
+		name:        "明天天气",
+		scopes:      scopeAll,
+		role:        roleUser,
+		description: "查询保存地址明天的天气预报",
+		feature:     featureDaily,
+		cost:        DailyCost,
+		handler:     weatherHandler("明天天气", (*service.Caiyun).Tomorrow),
+	})
+	registerCommand(&command{
+		name:        ".weather.help",
+		scopes:      scopeAll,
+		role:        roleAdmin,
+		args:        []argSpec{{name: "指令", kind: argRest, optional: true, description: "查看单个指令的详细用法"}},
+		description: "列出可用的指令，包括管理员指令",
+		examples:    []string{".weather.help", ".weather.help .weather.blacklist.add"},
+		handler:     func(ctx *commandContext) string { return helpMessage(ctx, ctx.str("指令")) },
+	})
+	registerCommand(&command{
+		name:   ".weather.group.set",
+		scopes: scopeGroup,
+		role:   roleGroupAdmin,
+		args: []argSpec{
+			{name: "设置项", kind: argString, optional: true, description: "realtime、nowcast、daily、nlp、limit 或 verbosity，缺省时显示当前设置"},
+			{name: "值", kind: argString, optional: true, description: "功能开关为 on 或 off，limit 为次数，verbosity 为 brief、normal 或 full"},
+		},
+		description: "修改本群的功能开关、调用次数上限和输出详细程度，模块管理员和群主、群管理员可用",
+		examples:    []string{".weather.group.set", ".weather.group.set realtime off", ".weather.group.set limit 5", ".weather.group.set verbosity brief"},
+		handler: func(ctx *commandContext) string {
+			return setGroupSetting(ctx.groupCode, ctx.str("设置项"), ctx.str("值"))
+		},
+	})
+	registerCommand(&command{
+		name:        ".weather.list",
+		scopes:      scopeAll,
+		role:        roleAdmin,
+		args:        []argSpec{{name: "名单", kind: argString, description: "blacklist 黑名单、whitelist 白名单、allowed 许可名单或 admins 管理员"}},
+		description: "查看名单",
+		examples:    []string{".weather.list blacklist", ".weather.list allowed"},
+		handler:     func(ctx *commandContext) string { return showList(ctx.str("名单")) },
+	})
+	registerCommand(&command{
+		name:        ".weather.user",
+		scopes:      scopeAll,
+		role:        roleAdmin,
+		args:        []argSpec{{name: "uin", kind: argUin, description: "用户 QQ 号，群聊中也可以直接 @ 用户"}},
+		description: "查看用户的地址、今日调用次数和创建、更新时间",
+		examples:    []string{".weather.user 1227427929"},
+		handler:     func(ctx *commandContext) string { return showUser(ctx.uin("uin")) },
+	})
+	registerCommand(&command{
+		name:   ".weather.user.tier",
+		scopes: scopeAll,
+		role:   roleAdmin,
+		args: []argSpec{
+			{name: "uin", kind: argUin, description: "用户 QQ 号，群聊中也可以直接 @ 用户"},
+			{name: "等级", kind: argString, optional: true, description: "default、trusted、vip、unlimited 或配置文件中自定义的等级，缺省时查看当前等级"},
+		},
+		description: "查看或修改用户的等级，不同等级有各自的每日次数和查询频率限制",
+		examples:    []string{".weather.user.tier 1227427929", ".weather.user.tier 1227427929 trusted"},
+		handler:     func(ctx *commandContext) string { return setUserTier(ctx.uin("uin"), ctx.str("等级")) },
+	})
+	registerCommand(&command{
+		name:        ".weather.top",
+		scopes:      scopeAll,
+		role:        roleAdmin,
+		args:        []argSpec{{name: "数量", kind: argInt, optional: true, description: fmt.Sprintf("显示的用户数量，默认 %d，最多 %d", DefaultTopCount, MaxTopCount)}},
+		description: "查看今天调用次数最多的用户",
+		examples:    []string{".weather.top", ".weather.top 20"},
+		handler:     func(ctx *commandContext) string { return showTopUsers(ctx.num("数量")) },
+	})
+	registerCommand(&command{
+		name:   ".weather.budget",
+		scopes: scopeAll,
+		role:   roleAdmin,
+		args: []argSpec{
+			{name: "目标", kind: argString, optional: true, description: "群号或 private（私聊和频道），缺省时为当前群或私聊"},
+			{name: "额度", kind: argString, optional: true, description: "每日调用次数，default 使用全局设置，unlimited 不限，缺省时只查看"},
+		},
+		description: "查看或修改群、私聊每日的调用额度",
+		examples:    []string{".weather.budget", ".weather.budget 1149558764 500", ".weather.budget private unlimited"},
+		handler: func(ctx *commandContext) string {
+			return setBudget(ctx, ctx.str("目标"), ctx.str("额度"))
+		},
+	})
+	registerCommand(&command{
+		name:        ".weather.clear.times",
+		scopes:      scopeAll,
+		role:        roleAdmin,
+		args:        []argSpec{{name: "uin", kind: argUins, description: "一个或多个用户 QQ 号，群聊中也可以直接 @ 用户"}},
+		description: "清空用户当天的调用次数",
+		examples:    []string{".weather.clear.times 1227427929", ".weather.clear.times 1227427929 1781924496"},
+		handler:     func(ctx *commandContext) string { return clearUserTimes(ctx.uins("uin")) },
+	})
+	registerCommand(&command{
+		name:        ".weather.blacklist.add",
+		scopes:      scopeAll,
+		role:        roleAdmin,
+		args:        userListArgs,
+		description: "添加用户到黑名单",
+		examples:    []string{".weather.blacklist.add 1227427929", ".weather.blacklist.add 1227427929 1781924496 刷屏"},
+		handler:     func(ctx *commandContext) string { return addUserToBlacklist(ctx, ctx.uins("uin")) },
+	})
+	registerCommand(&command{
+		name:        ".weather.blacklist.remove",
+		scopes:      scopeAll,
+		role:        roleAdmin,
+		args:        userListArgs,
+		description: "从黑名单移除用户",
+		examples:    []string{".weather.blacklist.remove 1227427929", ".weather.blacklist.remove 1227427929 1781924496"},
+		handler:     func(ctx *commandContext) string { return removeUserFromBlacklist(ctx, ctx.uins("uin")) },
+	})
+	registerCommand(&command{
+		name:        ".weather.whitelist.add",
+		scopes:      scopeAll,
+		role:        roleAdmin,
+		args:        userListArgs,
+		description: "添加用户到白名单，白名单用户不受调用次数限制",
+		examples:    []string{".weather.whitelist.add 1227427929", ".weather.whitelist.add 1227427929 1781924496"},
+		handler:     func(ctx *commandContext) string { return addUserToWhitelist(ctx, ctx.uins("uin")) },
+	})
+	registerCommand(&command{
+		name:        ".weather.whitelist.remove",
+		scopes:      scopeAll,
+		role:        roleAdmin,
+		args:        userListArgs,
+		description: "从白名单移除用户",
+		examples:    []string{".weather.whitelist.remove 1227427929", ".weather.whitelist.remove 1227427929 1781924496"},
+		handler:     func(ctx *commandContext) string { return removeUserFromWhitelist(ctx, ctx.uins("uin")) },
+	})
+	registerCommand(&command{
+		name:        ".weather.admin.add",
+		scopes:      scopeAll,
+		role:        roleAdmin,
+		args:        userListArgs,
+		description: "添加管理员",
+		examples:    []string{".weather.admin.add 1227427929"},
+		handler: func(ctx *commandContext) string {
+			return updateAccessList(ctx, listAdmins, ctx.uins("uin"), true, "用户", "管理员")
+		},
+	})
+	registerCommand(&command{
+		name:        ".weather.admin.remove",
+		scopes:      scopeAll,
+		role:        roleAdmin,
+		args:        userListArgs,
+		description: "移除管理员，至少需要保留一个管理员",
+		examples:    []string{".weather.admin.remove 1227427929"},
+		handler:     func(ctx *commandContext) string { return removeAdmins(ctx, ctx.uins("uin")) },
+	})
+	registerCommand(&command{
+		name:   ".weather.allowed",
+		scopes: scopeAll,
+		role:   roleAdmin,
+		args: []argSpec{
+			{name: "操作", kind: argString, optional: true, description: "add 添加、remove 移除或 list 查询，指定群号时必须写明；缺省时添加当前群"},
+			{name: "群号", kind: argUins, optional: true, description: "一个或多个群号，群聊中缺省时为当前群"},
+			reasonArg,
+		},
+		description: "添加群到许可名单或从许可名单移除，许可名单内的群才会提供服务",
+		examples:    []string{".weather.allowed", ".weather.allowed add 857066811 1149558764", ".weather.allowed remove 857066811", ".weather.allowed list 857066811", ".weather.allowed list"},
+		handler:     allowedHandler,
+	})
+	registerCommand(&command{
+		name:        ".weather.disallowed",
+		scopes:      scopeAll,
+		role:        roleAdmin,
+		args:        []argSpec{{name: "群号", kind: argUins, optional: true, description: "一个或多个群号，群聊中缺省时为当前群"}, reasonArg},
+		description: "将群移出许可名单",
+		examples:    []string{".weather.disallowed", ".weather.disallowed 857066811"},
+		handler: func(ctx *commandContext) string {
+			return groupListHandler(ctx, ctx.uins("群号"), removeGroupFromAllowed)
+		},
+	})
+	registerCommand(&command{
+		name:        ".weather.audit",
+		scopes:      scopeAll,
+		role:        roleAdmin,
+		args:        []argSpec{{name: "数量", kind: argInt, optional: true, description: fmt.Sprintf("显示的记录数量，默认 %d，最多 %d", DefaultAuditCount, MaxAuditCount)}},
+		description: "查看最近的名单修改记录，包括操作者、操作、号码、时间和原因",
+		examples:    []string{".weather.audit", ".weather.audit 30"},
+		handler:     func(ctx *commandContext) string { return showAudit(ctx.num("数量")) },
+	})
+	registerCommand(&command{
+		name:        ".weather.reload",
+		scopes:      scopeAll,
+		role:        roleAdmin,
+		description: "重新加载配置文件，配置有误时保留当前配置；配置文件修改后也会自动重新加载",
+		examples:    []string{".weather.reload"},
+		handler:     reloadHandler,
+	})
+	registerCommand(&command{
+		name:        ".weather.config.check",
+		scopes:      scopeAll,
+		role:        roleAdmin,
+		args:        []argSpec{{name: "路径", kind: argRest, optional: true, description: "要检查的配置文件路径，只能是配置文件所在目录内的文件，相对路径按该目录计算，默认为当前的配置文件"}},
+		description: "检查配置文件，列出全部问题及其位置，可以在替换配置文件或重新加载之前使用",
+		examples:    []string{".weather.config.check", ".weather.config.check weather.new.yaml"},
+		handler:     checkConfigHandler,
+	})
+	registerCommand(&command{
+		name:   ".weather.config.set",
+		scopes: scopeAll,
+		role:   roleAdmin,
+		args: []argSpec{
+			{name: "配置项", kind: argString, description: "配置文件中的项，如 limit、rate_limit.cooldown、reply.at，key、数据库和名单不能修改"},
+			{name: "值", kind: argString, description: "数值、on 或 off、或者字符串"},
+		},
+		description: "修改配置文件中的一项并立即生效，只改动这一项，保留注释；修改前备份原文件，修改后的配置有误时不写入",
+		examples:    []string{".weather.config.set limit 10", ".weather.config.set rate_limit.cooldown 30", ".weather.config.set reply.at on"},
+		handler:     setConfigHandler,
+	})
+}
+
+// weatherHandler 查询天气的指令处理函数
+func weatherHandler(title string, apiCalled func(*service.Caiyun, float64, float64) (string, error)) func(ctx *commandContext) string {
+	return func(ctx *commandContext) string {
+		return callWeatherAPI(ctx, title, apiCalled)
+	}
+}
+
+// allowedHandler 许可名单指令，操作缺省时为添加当前群
+// 指定群号时必须写明操作，「.weather.allowed 857066811」既可能是添加也可能是查询，不猜测
+func allowedHandler(ctx *commandContext) string {
+	op, groupCodes := ctx.str("操作"), ctx.uins("群号")
+	if _, err := parseUin(op); err == nil {
+		trigger := triggerOf(".weather.allowed")
+		return fmt.Sprintf("请写明操作，如「%s add %s」添加或「%s list %s」查询。", trigger, op, trigger, op)
+	}
+	switch op {
+	case "", "add":
+		return groupListHandler(ctx, groupCodes, addGroupToAllowed)
+	case "remove":
+		return groupListHandler(ctx, groupCodes, removeGroupFromAllowed)
+	case "list":
+		if len(groupCodes) == 0 && ctx.scope != scopeGroup {
+			return showList(listAllowed)
+		}
+		return groupListHandler(ctx, groupCodes, queryAllowedGroups)
+	}
+	return usageError(findCommand(".weather.allowed"))
+}
+
+// queryAllowedGroups 查询群是否在许可名单中
+func queryAllowedGroups(ctx *commandContext, groupCodes []int64) string {
+	lines := make([]string, 0, len(groupCodes))
+	for _, groupCode := range groupCodes {
+		if isAllowedGroup(groupCode) {
+			lines = append(lines, fmt.Sprintf("群 %d 在许可名单中。", groupCode))
+		} else {
+			lines = append(lines, fmt.Sprintf("群 %d 不在许可名单中。", groupCode))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// groupListHandler 修改群名单，未指定群号时在群聊中使用当前群
+func groupListHandler(ctx *commandContext, groupCodes []int64, update func(*commandContext, []int64) string) string {
+	if len(groupCodes) == 0 {
+		if ctx.scope != scopeGroup {
+			return "私聊和频道中需要指定群号。"
+		}
+		groupCodes = []int64{ctx.groupCode}
+	}
+	return update(ctx, groupCodes)
+}
@@ -0,0 +1,147 @@
+package weather
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/Logiase/MiraiGo-Template/config"
+	"github.com/Mrs4s/MiraiGo/message"
+	"github.com/spf13/viper"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/database/model"
+	"gopkg.in/yaml.v3"
+)
+
+// TestConcurrentConfigUpdates 管理员修改配置、名单、触发词和配置文件的同时处理用户消息
+// 需要使用 go test -race 运行才能发现数据竞争
+func TestConcurrentConfigUpdates(t *testing.T) {
+	const (
+		groupCode int64 = 857066811
+		admin     int64 = 1227427929
+		user      int64 = 1781924496
+	)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "weather.yaml")
+	data := "key: test # 彩云天气 key\nlimit: 5\ndb:\n  type: sqlite\n  sqlite:\n    path: " + filepath.Join(dir, "weather.db") + "\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 测试结束后恢复替换的全局状态，之后的测试不受执行顺序影响
+	globalConfig, snapshot, triggers, hash := config.GlobalConfig, weatherConfig.Load(), activeTriggers.Load(), configFile.hash
+	accessLists.RLock()
+	lists := accessLists.ids
+	accessLists.RUnlock()
+	t.Cleanup(func() {
+		config.GlobalConfig = globalConfig
+		weatherConfig.Store(snapshot)
+		activeTriggers.Store(triggers)
+		setAccessLists(lists)
+		configFile.Lock()
+		configFile.hash = hash
+		configFile.Unlock()
+		groupSettingCache.Lock()
+		delete(groupSettingCache.settings, groupCode)
+		groupSettingCache.Unlock()
+	})
+	config.GlobalConfig = &config.Config{Viper: viper.New()}
+	config.GlobalConfig.Set("aimerneige.weather.path", path)
+	setConfig(Config{Key: "test", Limit: 5})
+	setAccessLists(map[string][]int64{listAdmins: {admin}, listAllowed: {groupCode}})
+	if err := configureTriggers("", nil); err != nil {
+		t.Fatal(err)
+	}
+	// 预先缓存群设置，处理消息时不读取数据库
+	groupSettingCache.Lock()
+	groupSettingCache.settings[groupCode] = model.DefaultGroupSetting(groupCode)
+	groupSettingCache.Unlock()
+
+	var writers, readers sync.WaitGroup
+	stop := make(chan struct{})
+	writers.Add(4)
+	go func() {
+		defer writers.Done()
+		for i := 0; i < 200; i++ {
+			setConfig(Config{Key: "test", Limit: i + 1})
+		}
+	}()
+	go func() {
+		defer writers.Done()
+		for i := 0; i < 200; i++ {
+			setAccessLists(map[string][]int64{
+				listAdmins:    {admin},
+				listAllowed:   {groupCode, int64(i)},
+				listBlacklist: {int64(i)},
+			})
+		}
+	}()
+	go func() {
+		defer writers.Done()
+		for i := 0; i < 200; i++ {
+			prefix := ".weather"
+			if i%2 == 1 {
+				prefix = "!weather"
+			}
+			if err := configureTriggers(prefix, map[string][]string{"天气帮助": {"天气帮助", "帮助"}}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer writers.Done()
+		for i := 0; i < 20; i++ {
+			limit := i + 1
+			_, err := updateWeatherConfigFile(func(doc *yaml.Node) error {
+				return setConfigValue(doc, limit, "limit")
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	contexts := []*commandContext{
+		{scope: scopeGroup, sender: &message.Sender{Uin: user}, groupCode: groupCode},
+		{scope: scopeGroup, sender: &message.Sender{Uin: admin}, groupCode: groupCode},
+		{scope: scopePrivate, sender: &message.Sender{Uin: admin}},
+	}
+	messages := []string{"天气帮助", ".weather.help", "!weather.help", "明天会下雨吗"}
+	for _, ctx := range contexts {
+		readers.Add(1)
+		go func(base commandContext) {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for _, msg := range messages {
+					ctx := base
+					dispatch(&ctx, msg)
+				}
+				if !inList(listAdmins, admin) || !inList(listAllowed, groupCode) {
+					t.Error("access lists lost an entry during update")
+					return
+				}
+				if c := currentConfig(); c.Key != "test" || c.Limit <= 0 {
+					t.Errorf("unexpected config snapshot: %v", c.Config)
+					return
+				}
+			}
+		}(*ctx)
+	}
+	writers.Wait()
+	close(stop)
+	readers.Wait()
+
+	c, _, problems, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) > 0 || c.Limit != 20 {
+		t.Errorf("config file after updates: limit %d, problems %v", c.Limit, problems)
+	}
+}
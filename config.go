@@ -0,0 +1,386 @@
+package weather
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/geo"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/pkg"
+	"gopkg.in/yaml.v3"
+)
+
+// replyOption 群聊回复是否引用消息、是否 @ 发送者
+type replyOption struct {
+	quote bool
+	at    bool
+}
+
+// configSnapshot 配置快照，创建后不再修改，重新加载或修改配置时整体替换，可以在多个协程中同时读取
+// 修改配置时需要复制一份 Config，不能修改快照中的切片和 map
+type configSnapshot struct {
+	Config
+
+	resetLoc    *time.Location             // 每日调用次数清零使用的时区
+	reply       replyOption                // 默认的群聊回复方式
+	replyGroups map[int64]replyOption      // 单独配置了回复方式的群
+	mentionOnly map[int64]bool             // 需要 @ 机器人才响应的群
+	nlpGroups   map[int64]bool             // 开启自然语言问答的群
+	channels    map[uint64]map[uint64]bool // 允许的频道和子频道，子频道为 0 时允许频道内全部子频道
+}
+
+// defaultGeocoder 内置的地名数据
+var defaultGeocoder = geo.DefaultGeocoder()
+
+// String 用于日志输出，隐藏 key 和数据库密码
+func (c Config) String() string {
+	c.Key = pkg.Redact(c.Key)
+	c.DB.MySQL.Password = pkg.Redact(c.DB.MySQL.Password)
+	type plain Config
+	return fmt.Sprintf("%+v", plain(c))
+}
+
+// GoString 用于 %#v 输出，隐藏 key 和数据库密码
+func (c Config) GoString() string {
+	return "weather.Config" + c.String()
+}
+
+// emptyConfig 加载配置文件之前使用的空配置
+var emptyConfig = newConfigSnapshot(Config{})
+
+// weatherConfig 当前生效的配置
+var weatherConfig atomic.Pointer[configSnapshot]
+
+// geocoder 当前使用的地名数据，重新加载配置时可能替换
+var geocoder atomic.Pointer[geo.Geocoder]
+
+// currentConfig 当前生效的配置快照，同一次处理中多次读取配置时应只获取一次
+func currentConfig() *configSnapshot {
+	if c := weatherConfig.Load(); c != nil {
+		return c
+	}
+	return emptyConfig
+}
+
+// currentGeocoder 当前使用的地名数据，未配置时使用内置数据
+func currentGeocoder() *geo.Geocoder {
+	if g := geocoder.Load(); g != nil {
+		return g
+	}
+	return defaultGeocoder
+}
+
+// setConfig 替换当前生效的配置
+func setConfig(c Config) {
+	weatherConfig.Store(newConfigSnapshot(c))
+}
+
+// newConfigSnapshot 创建配置快照，预先计算查找用的集合
+func newConfigSnapshot(c Config) *configSnapshot {
+	s := &configSnapshot{
+		Config:      c,
+		resetLoc:    loadResetLocation(c.ResetTimezone),
+		reply:       replyOption{quote: true, at: c.Reply.At},
+		replyGroups: make(map[int64]replyOption, len(c.Reply.Groups)),
+		mentionOnly: make(map[int64]bool, len(c.Commands.MentionOnly)),
+		nlpGroups:   make(map[int64]bool, len(c.NaturalLanguage.Groups)),
+		channels:    make(map[uint64]map[uint64]bool, len(c.Guild.Allowed)),
+	}
+	if c.Reply.Quote != nil {
+		s.reply.quote = *c.Reply.Quote
+	}
+	for _, g := range c.Reply.Groups {
+		option, ok := s.replyGroups[g.GroupCode]
+		if !ok {
+			option = s.reply
+		}
+		if g.Quote != nil {
+			option.quote = *g.Quote
+		}
+		if g.At != nil {
+			option.at = *g.At
+		}
+		s.replyGroups[g.GroupCode] = option
+	}
+	for _, groupCode := range c.Commands.MentionOnly {
+		s.mentionOnly[groupCode] = true
+	}
+	for _, groupCode := range c.NaturalLanguage.Groups {
+		s.nlpGroups[groupCode] = true
+	}
+	for _, v := range c.Guild.Allowed {
+		if s.channels[v.GuildID] == nil {
+			s.channels[v.GuildID] = make(map[uint64]bool)
+		}
+		s.channels[v.GuildID][v.ChannelID] = true
+	}
+	return s
+}
+
+// ConfigBackupCount 修改配置文件时保留的备份数量，超出时删除最旧的备份
+const ConfigBackupCount int = 5
+
+// backupTimeLayout 备份文件名中的时间，按文件名排序即为按时间排序
+const backupTimeLayout string = "20060102-150405.000"
+
+// settingKind 配置项的值的类型
+type settingKind int
+
+const (
+	settingInt    settingKind = iota // 整数
+	settingSwitch                    // 开关，on 或 off
+	settingString                    // 字符串
+)
+
+// configSettings 可以通过「.weather.config.set」修改的配置项及其类型，只包括数值、开关和普通字符串
+// key、数据库、名单和涉及文件路径的配置不能在聊天中修改
+var configSettings = map[string]settingKind{
+	"limit":                    settingInt,
+	"group_limit":              settingInt,
+	"private_limit":            settingInt,
+	"reset_timezone":           settingString,
+	"commands.prefix":          settingString,
+	"reply.quote":              settingSwitch,
+	"reply.at":                 settingSwitch,
+	"natural_language.private": settingSwitch,
+	"dedupe.window":            settingInt,
+	"rate_limit.cooldown":      settingInt,
+	"rate_limit.window":        settingInt,
+	"rate_limit.window_limit":  settingInt,
+	"rate_limit.group_window":  settingInt,
+	"rate_limit.group_burst":   settingInt,
+}
+
+// setConfigHandler 「.weather.config.set」修改配置文件中的一项并立即生效
+func setConfigHandler(ctx *commandContext) string {
+	name, raw := ctx.str("配置项"), ctx.str("值")
+	kind, ok := configSettings[name]
+	if !ok {
+		names := make([]string, 0, len(configSettings))
+		for k := range configSettings {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return fmt.Sprintf("不能通过指令修改「%s」，可以修改的配置项：%s。", name, strings.Join(names, "、"))
+	}
+	var value interface{} = raw
+	switch kind {
+	case settingInt:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Sprintf("「%s」需要是整数。", name)
+		}
+		value = n
+	case settingSwitch:
+		on, ok := parseSwitch(raw)
+		if !ok {
+			return fmt.Sprintf("「%s」需要是 on 或 off。", name)
+		}
+		value = on
+	}
+	oldValue := "未设置"
+	notes, err := updateWeatherConfigFile(func(doc *yaml.Node) error {
+		path := strings.Split(name, ".")
+		if node := findConfigValue(doc, path...); node != nil && node.Kind == yaml.ScalarNode {
+			oldValue = node.Value
+		}
+		return setConfigValue(doc, value, path...)
+	})
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to set %s in config file.", name)
+		return err.Error()
+	}
+	logger.Infof("Config %s set from %s to %v by %d.", name, oldValue, value, ctx.sender.Uin)
+	lines := append([]string{fmt.Sprintf("已将「%s」从 %s 修改为 %v，修改前的配置文件已备份。", name, oldValue, value)}, notes...)
+	return strings.Join(lines, "\n")
+}
+
+// updateWeatherConfigFile 修改配置文件并使修改生效，返回需要提示的注意事项
+// edit 直接修改配置文件的节点树，保留原有的注释和顺序；写入前备份原文件，先写入临时文件再替换，中途出错不会损坏原文件
+// 节点树来自磁盘上的文件，${ENV} 和 *_file 原样保留，不会把解析后的密钥写入文件；修改后的配置有误时不写入
+func updateWeatherConfigFile(edit func(doc *yaml.Node) error) ([]string, error) {
+	configFile.Lock()
+	defer configFile.Unlock()
+	path, err := filepath.EvalSymlinks(configPath())
+	if err != nil {
+		return nil, err
+	}
+	old, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(old, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if err := edit(&doc); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	newConfig, problems, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("修改后的配置有误，未修改配置文件：\n%s", strings.Join(problems, "\n"))
+	}
+	if err := backupConfigFile(path, old); err != nil {
+		return nil, fmt.Errorf("backup config file: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return nil, err
+	}
+	configFile.hash = sha256.Sum256(data)
+	oldConfig := currentConfig().Config
+	setConfig(newConfig)
+	return applyConfig(oldConfig, newConfig), nil
+}
+
+// findConfigValue 查找配置文件节点树中 path 对应的值，不存在时返回 nil
+func findConfigValue(doc *yaml.Node, path ...string) *yaml.Node {
+	node := doc
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	for _, key := range path {
+		_, value := findConfigKey(node, key)
+		if value == nil {
+			return nil
+		}
+		node = value
+	}
+	return node
+}
+
+// findConfigKey 在映射节点中查找键，返回键和值的节点，不存在时返回 nil
+func findConfigKey(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// setConfigValue 设置配置文件节点树中 path 对应的值，不存在时添加到末尾，保留原有的注释
+func setConfigValue(doc *yaml.Node, value interface{}, path ...string) error {
+	node := doc
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	var keyNode *yaml.Node
+	for i, key := range path {
+		if node.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s is not a mapping", strings.Join(path[:i], "."))
+		}
+		k, next := findConfigKey(node, key)
+		if next == nil {
+			k, next = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			node.Content = append(node.Content, k, next)
+		}
+		keyNode, node = k, next
+	}
+	var encoded yaml.Node
+	if err := encoded.Encode(value); err != nil {
+		return err
+	}
+	encoded.HeadComment, encoded.LineComment, encoded.FootComment = node.HeadComment, node.LineComment, node.FootComment
+	// 「allowed: # 注释」的注释属于键，新的值与键在同一行输出时注释移到值上，否则会错位到下一个键
+	inline := encoded.Kind == yaml.ScalarNode || encoded.Style&yaml.FlowStyle != 0 || len(encoded.Content) == 0
+	if keyNode != nil && inline && keyNode.LineComment != "" && encoded.LineComment == "" {
+		encoded.LineComment, keyNode.LineComment = keyNode.LineComment, ""
+	}
+	*node = encoded
+	return nil
+}
+
+// backupConfigFile 备份修改前的配置文件，备份名为「weather.yaml.20060102-150405.000.bak」，只保留最近的 ConfigBackupCount 个
+func backupConfigFile(path string, data []byte) error {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	name := fmt.Sprintf("%s.%s.bak", path, time.Now().Format(backupTimeLayout))
+	if err := os.WriteFile(name, data, perm); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	prefix := filepath.Base(path) + "."
+	var backups []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) && strings.HasSuffix(entry.Name(), ".bak") {
+			backups = append(backups, entry.Name())
+		}
+	}
+	sort.Strings(backups)
+	for i := 0; i < len(backups)-ConfigBackupCount; i++ {
+		if err := os.Remove(filepath.Join(filepath.Dir(path), backups[i])); err != nil {
+			logger.WithError(err).Errorf("Fail to remove old config backup %s.", backups[i])
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic 写入文件，先写入同目录下的临时文件并同步到磁盘，再替换原文件
+func writeFileAtomic(path string, data []byte) error {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	// 重命名成功后临时文件已不存在，删除失败可以忽略
+	defer os.Remove(tmp)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// 同步目录，保证断电后重命名依然有效
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
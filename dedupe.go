@@ -0,0 +1,70 @@
+package weather
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Mrs4s/MiraiGo/message"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/nlp"
+)
+
+// DedupeMessage 群内重复查询时的回复，同时引用之前的回答
+const DedupeMessage string = "刚刚已经查询过相同地区的天气，请看这条回复～"
+
+// recentAnswers 群内最近的天气回答，用于识别重复查询
+var recentAnswers = struct {
+	sync.Mutex
+	answers map[string]recentAnswer
+}{answers: make(map[string]recentAnswer)}
+
+// recentAnswer 已经发送的天气回答
+type recentAnswer struct {
+	msg *message.GroupMessage
+	at  time.Time
+}
+
+// dedupeKey 群内查询的去重键，同一群、同一查询标题和话题、相同地区和显示时区的查询视为重复
+// 地区按 0.1 度（约 10 千米）的网格划分，不使用地名，同一地级市内相距很远的地点不会被当作重复
+func dedupeKey(ctx *commandContext, title string, longitude, latitude float64, timezone string) string {
+	return fmt.Sprintf("%d|%s|%s|%.1f,%.1f|%s", ctx.groupCode, title, ctx.topic, longitude, latitude, timezone)
+}
+
+// questionTopic 自然语言问题的去重话题，标题相同但要素或时段不同的问题（如「明天会下雨吗」和「明天冷不冷」）回答不同
+func questionTopic(q nlp.Query) string {
+	return fmt.Sprintf("%d|%d-%d", q.Aspect, q.FromHour, q.ToHour)
+}
+
+// dedupeWindow 重复查询的判定时间，为 0 时不去重
+func dedupeWindow() time.Duration {
+	return time.Duration(currentConfig().Dedupe.Window) * time.Second
+}
+
+// findRecentAnswer 查找群内 dedupeWindow 内相同查询的回答
+func findRecentAnswer(key string, now time.Time) *message.GroupMessage {
+	window := dedupeWindow()
+	if window <= 0 {
+		return nil
+	}
+	recentAnswers.Lock()
+	defer recentAnswers.Unlock()
+	for k, v := range recentAnswers.answers {
+		if now.Sub(v.at) >= window {
+			delete(recentAnswers.answers, k)
+		}
+	}
+	if answer, ok := recentAnswers.answers[key]; ok {
+		return answer.msg
+	}
+	return nil
+}
+
+// rememberAnswer 记录群内发送成功的天气回答
+func rememberAnswer(key string, msg *message.GroupMessage, now time.Time) {
+	if dedupeWindow() <= 0 || msg == nil {
+		return
+	}
+	recentAnswers.Lock()
+	defer recentAnswers.Unlock()
+	recentAnswers.answers[key] = recentAnswer{msg: msg, at: now}
+}
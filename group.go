@@ -0,0 +1,201 @@
+package weather
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/database"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/database/model"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/service"
+)
+
+// 可以按群开关的功能
+// 模块目前没有逐小时预报和天气预警查询，hourly、alerts 开关不在这次的范围内，加入对应的指令时再一起添加
+const (
+	featureRealtime        = "realtime"
+	featureNowcast         = "nowcast"
+	featureDaily           = "daily"
+	featureNaturalLanguage = "nlp"
+)
+
+// groupSettingKeys 「.weather.group.set」可以修改的设置项
+var groupSettingKeys = []struct {
+	key         string
+	column      string
+	description string
+}{
+	{featureRealtime, "realtime", "实时天气"},
+	{featureNowcast, "nowcast", "出门建议"},
+	{featureDaily, "daily", "今天、明天天气和定时推送"},
+	{featureNaturalLanguage, "natural_language", "自然语言问答"},
+	{"limit", "daily_limit", "每人每日调用次数上限，0 表示使用全局设置"},
+	{"verbosity", "verbosity", "输出的详细程度 brief | normal | full"},
+}
+
+// groupSettingCache 群设置缓存，修改设置时更新
+// 读取数据库时不持有锁，version 在清除缓存时增加，读取期间设置被修改时不缓存读到的旧设置
+var groupSettingCache = struct {
+	sync.Mutex
+	settings map[int64]model.GroupSetting
+	version  uint64
+}{settings: make(map[int64]model.GroupSetting)}
+
+// groupSetting 获取群设置，读取数据库失败时使用默认设置
+func groupSetting(groupCode int64) model.GroupSetting {
+	groupSettingCache.Lock()
+	setting, ok := groupSettingCache.settings[groupCode]
+	version := groupSettingCache.version
+	groupSettingCache.Unlock()
+	if ok {
+		return setting
+	}
+	setting, err := service.NewDBService(database.GetDB()).GetGroupSetting(groupCode)
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to get group setting.")
+		return model.DefaultGroupSetting(groupCode)
+	}
+	groupSettingCache.Lock()
+	if groupSettingCache.version == version {
+		groupSettingCache.settings[groupCode] = setting
+	}
+	groupSettingCache.Unlock()
+	return setting
+}
+
+// groupFeatureEnabled 群是否开启了功能
+func groupFeatureEnabled(groupCode int64, feature string) bool {
+	setting := groupSetting(groupCode)
+	switch feature {
+	case featureRealtime:
+		return setting.Realtime
+	case featureNowcast:
+		return setting.Nowcast
+	case featureDaily:
+		return setting.Daily
+	case featureNaturalLanguage:
+		// 用「.weather.group.set nlp」设置过时以群设置为准，否则按配置文件
+		if setting.NaturalLanguage != nil {
+			return *setting.NaturalLanguage
+		}
+		return currentConfig().nlpGroups[groupCode]
+	}
+	return true
+}
+
+// dailyLimit 当前场景的每人每日调用次数上限，群设置优先
+func dailyLimit(ctx *commandContext) int {
+	if ctx.scope == scopeGroup {
+		if limit := groupSetting(ctx.groupCode).DailyLimit; limit > 0 {
+			return limit
+		}
+	}
+	return currentConfig().Limit
+}
+
+// verbosity 当前场景的输出详细程度，只有群可以设置
+func verbosity(ctx *commandContext) service.Verbosity {
+	if ctx.scope == scopeGroup {
+		if v, ok := service.ParseVerbosity(groupSetting(ctx.groupCode).Verbosity); ok {
+			return v
+		}
+	}
+	return service.VerbosityFull
+}
+
+// setGroupSetting 修改群设置，key 为空时显示当前设置
+func setGroupSetting(groupCode int64, key, value string) string {
+	if key == "" {
+		return groupSettingMessage(groupCode)
+	}
+	column := ""
+	for _, v := range groupSettingKeys {
+		if v.key == key {
+			column = v.column
+		}
+	}
+	if column == "" {
+		keys := make([]string, len(groupSettingKeys))
+		for i, v := range groupSettingKeys {
+			keys[i] = v.key
+		}
+		return fmt.Sprintf("未知设置项「%s」，可选：%s。", key, strings.Join(keys, "、"))
+	}
+	if value == "" {
+		return fmt.Sprintf("参数错误，正确的格式：「%s %s <值>」。", triggerOf(".weather.group.set"), key)
+	}
+	var parsed interface{}
+	switch key {
+	case "limit":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Sprintf("解析失败，「%s」不是正确的次数。", value)
+		}
+		parsed = n
+	case "verbosity":
+		v, ok := service.ParseVerbosity(value)
+		if !ok {
+			return fmt.Sprintf("解析失败，「%s」不是正确的详细程度，可选：brief、normal、full。", value)
+		}
+		parsed = string(v)
+	default:
+		on, ok := parseSwitch(value)
+		if !ok {
+			return fmt.Sprintf("解析失败，「%s」不是正确的开关，可选：on、off。", value)
+		}
+		parsed = on
+	}
+	if err := service.NewDBService(database.GetDB()).UpdateGroupSetting(groupCode, column, parsed); err != nil {
+		logger.WithError(err).Errorf("Fail to update group setting.")
+		return DatabaseErrorMessage
+	}
+	invalidateGroupSetting(groupCode)
+	return "设置成功。\n" + groupSettingMessage(groupCode)
+}
+
+// invalidateGroupSetting 修改设置后清除群设置缓存
+func invalidateGroupSetting(groupCode int64) {
+	groupSettingCache.Lock()
+	delete(groupSettingCache.settings, groupCode)
+	groupSettingCache.version++
+	groupSettingCache.Unlock()
+}
+
+// groupSettingMessage 群的当前设置
+func groupSettingMessage(groupCode int64) string {
+	setting := groupSetting(groupCode)
+	lines := []string{fmt.Sprintf("群「%d」的设置：", groupCode)}
+	for _, v := range groupSettingKeys {
+		var value string
+		switch v.key {
+		case "limit":
+			value = fmt.Sprint(setting.DailyLimit)
+			if setting.DailyLimit == 0 {
+				value = fmt.Sprintf("0（全局设置 %d）", currentConfig().Limit)
+			}
+		case "verbosity":
+			level, _ := service.ParseVerbosity(setting.Verbosity)
+			value = string(level)
+		default:
+			value = "off"
+			if groupFeatureEnabled(groupCode, v.key) {
+				value = "on"
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s：%s（%s）", v.key, value, v.description))
+	}
+	lines = append(lines, budgetMessage(groupCode))
+	return strings.Join(lines, "\n")
+}
+
+// parseSwitch 解析开关
+func parseSwitch(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "on", "true", "1", "开", "开启":
+		return true, true
+	case "off", "false", "0", "关", "关闭":
+		return false, true
+	}
+	return false, false
+}
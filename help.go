@@ -0,0 +1,100 @@
+package weather
+
+import (
+	"fmt"
+	"strings"
+)
+
+// helpMessage 帮助信息，列出当前场景和用户可用的全部指令
+// name 不为空时显示单个指令的详细用法
+func helpMessage(ctx *commandContext, name string) string {
+	if name != "" {
+		return commandDetail(ctx, name)
+	}
+	var b strings.Builder
+	b.WriteString("天气模块可用指令：")
+	for _, cmd := range commands {
+		if !cmd.available(ctx) {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s", cmd.usage())
+		if cmd.description != "" {
+			fmt.Fprintf(&b, "\n  %s", cmd.description)
+		}
+		if len(cmd.examples) > 0 {
+			fmt.Fprintf(&b, "\n  示例：「%s」", cmd.example(0))
+		}
+	}
+	if naturalLanguageEnabled(ctx) {
+		b.WriteString("\n\n也可以直接提问，如「明天会下雨吗」「周末北京天气怎么样」。")
+	}
+	helpCommand := triggerOf("天气帮助")
+	if isAdmin(ctx.sender.Uin) {
+		helpCommand = triggerOf(".weather.help")
+	}
+	fmt.Fprintf(&b, "\n\n发送「%s 指令名称」查看详细用法。", helpCommand)
+	return b.String()
+}
+
+// commandDetail 单个指令的详细用法，指令名称可以是任一触发词
+func commandDetail(ctx *commandContext, name string) string {
+	var found *command
+	for _, t := range currentTriggers().triggers {
+		if t.word == name {
+			found = t.cmd
+			break
+		}
+	}
+	if found == nil || !found.available(ctx) {
+		return fmt.Sprintf("未找到指令「%s」，发送「%s」查看全部指令。", name, triggerOf("天气帮助"))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "用法：%s", found.usage())
+	if found.description != "" {
+		fmt.Fprintf(&b, "\n说明：%s", found.description)
+	}
+	if found.cost > DefaultCost {
+		fmt.Fprintf(&b, "\n消耗：每次查询计 %d 次", found.cost)
+	}
+	if words := currentTriggers().words[found]; len(words) > 1 {
+		fmt.Fprintf(&b, "\n别名：%s", strings.Join(words[1:], "、"))
+	}
+	for _, spec := range found.args {
+		if spec.description == "" {
+			continue
+		}
+		optional := ""
+		if spec.optional {
+			optional = "（可选）"
+		}
+		fmt.Fprintf(&b, "\n参数 %s%s：%s", spec.name, optional, spec.description)
+	}
+	if found.scopes != scopeAll {
+		var scopes []string
+		for _, v := range []struct {
+			scope scope
+			name  string
+		}{{scopeGroup, "群聊"}, {scopePrivate, "私聊"}, {scopeGuild, "频道"}} {
+			if found.scopes&v.scope != 0 {
+				scopes = append(scopes, v.name)
+			}
+		}
+		fmt.Fprintf(&b, "\n仅限%s使用", strings.Join(scopes, "、"))
+	}
+	for i := range found.examples {
+		if i == 0 {
+			b.WriteString("\n示例：")
+		}
+		fmt.Fprintf(&b, "\n  %s", found.example(i))
+	}
+	return b.String()
+}
+
+// example 第 i 个示例，示例中的默认触发词替换为生效的主触发词
+func (cmd *command) example(i int) string {
+	example := cmd.examples[i]
+	if strings.HasPrefix(example, cmd.name) {
+		return cmd.trigger() + strings.TrimPrefix(example, cmd.name)
+	}
+	return example
+}
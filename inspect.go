@@ -0,0 +1,204 @@
+package weather
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/database"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/database/model"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/service"
+	"gorm.io/gorm"
+)
+
+// DefaultTopCount 「.weather.top」默认显示的用户数量
+const DefaultTopCount int = 10
+
+// MaxTopCount 「.weather.top」最多显示的用户数量
+const MaxTopCount int = 50
+
+// timeLayout 管理员查询结果中的时间格式
+const timeLayout string = "2006-01-02 15:04:05"
+
+// listNames 可以查看的名单
+var listNames = map[string]string{
+	"blacklist": "黑名单",
+	"whitelist": "白名单",
+	"allowed":   "许可名单",
+	"admins":    "管理员",
+}
+
+// showList 查看名单，用户名单会附带保存地址时记录的昵称
+func showList(name string) string {
+	if _, ok := listNames[name]; !ok {
+		return fmt.Sprintf("未知名单「%s」，可选：blacklist、whitelist、allowed、admins。", name)
+	}
+	ids := listIDs(name)
+	title := listNames[name]
+	if len(ids) == 0 {
+		return title + "为空。"
+	}
+	names := make(map[int64]string)
+	if name != "allowed" {
+		users, err := service.NewDBService(database.GetDB()).GetUsers(ids)
+		if err != nil {
+			logger.WithError(err).Errorf("Fail to get users.")
+			return DatabaseErrorMessage
+		}
+		for _, user := range users {
+			names[user.Uin] = user.Name
+		}
+	}
+	lines := []string{fmt.Sprintf("%s（共 %d 个）：", title, len(ids))}
+	for _, id := range ids {
+		if userName, ok := names[id]; ok && userName != "" {
+			lines = append(lines, fmt.Sprintf("%d（%s）", id, userName))
+		} else {
+			lines = append(lines, fmt.Sprint(id))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// showUser 查看用户的地址、调用次数和名单状态
+func showUser(uin int64) string {
+	user, err := service.NewDBService(database.GetDB()).GetUser(uin)
+	if err == gorm.ErrRecordNotFound {
+		return fmt.Sprintf("用户「%d」还没有保存地址。%s", uin, listStatus(uin))
+	}
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to get user.")
+		return DatabaseErrorMessage
+	}
+	location, timezone := userLocation(user), userTimezone(user)
+	times := user.TimesOn(today())
+	tierName, tier := userTier(user)
+	limit := fmt.Sprintf("%d / %d", times, currentConfig().Limit)
+	if tier.Limit > 0 {
+		limit = fmt.Sprintf("%d / %d", times, tier.Limit)
+	}
+	if tier.Unlimited || tier.Limit < 0 {
+		limit = fmt.Sprintf("%d（不限次数）", times)
+	}
+	lines := []string{
+		"用户：" + userDisplayName(user),
+		"地址：" + location,
+		"时区：" + timezone,
+		"等级：" + tierName + "（" + tierDescription(tier) + "）",
+		"今日调用：" + limit,
+		"创建时间：" + user.CreatedAt.Format(timeLayout),
+		"更新时间：" + user.UpdatedAt.Format(timeLayout),
+	}
+	if status := listStatus(uin); status != "" {
+		lines = append(lines, status)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// showProfile 用户查看自己保存的地址、偏好、等级和今日剩余次数，不消耗调用次数
+func showProfile(ctx *commandContext) string {
+	user, err := service.NewDBService(database.GetDB()).GetUser(ctx.sender.Uin)
+	if err == gorm.ErrRecordNotFound {
+		return fmt.Sprintf("你还没有保存地址，发送「%s 经度 纬度」保存后即可查询天气。", triggerOf("修改地址"))
+	}
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to get user.")
+		return DatabaseErrorMessage
+	}
+	date := today()
+	times := user.TimesOn(date)
+	tierName, tier := userTier(user)
+	usage := fmt.Sprintf("%d 次（不限次数）", times)
+	if limit := userLimit(ctx, tier); limit >= 0 {
+		remaining := limit - times
+		if remaining < 0 {
+			remaining = 0
+		}
+		usage = fmt.Sprintf("%d / %d 次，剩余 %d 次", times, limit, remaining)
+	}
+	lines := []string{
+		"你的天气设置：",
+		"地址：" + userLocation(user),
+		"时区：" + userTimezone(user),
+		"等级：" + tierName + "（" + tierDescription(tier) + "）",
+		"今日调用：" + usage,
+	}
+	if ctx.scope == scopeGroup {
+		level, _ := service.ParseVerbosity(groupSetting(ctx.groupCode).Verbosity)
+		lines = append(lines, "本群输出详细程度："+string(level))
+	}
+	if pool := quotaPool(ctx); !tier.Unlimited && poolBudget(pool) >= 0 {
+		lines = append(lines, budgetMessage(pool))
+	}
+	lines = append(lines, fmt.Sprintf("发送「%s 经度 纬度」修改地址，「%s 时区」修改时区。", triggerOf("修改地址"), triggerOf("设置时区")))
+	return strings.Join(lines, "\n")
+}
+
+// userLocation 用户保存的地址，能解析出地名时附带地名
+func userLocation(user model.User) string {
+	location := fmt.Sprintf("%.4f, %.4f", user.Longitude, user.Latitude)
+	if label := locationLabel(user.Longitude, user.Latitude); label != "" {
+		location = label + "（" + location + "）"
+	}
+	return location
+}
+
+// userTimezone 用户显示时间使用的时区
+func userTimezone(user model.User) string {
+	if user.Timezone == "" {
+		return "预报地点当地时区"
+	}
+	return user.Timezone
+}
+
+// listStatus 用户所在的名单
+func listStatus(uin int64) string {
+	var lists []string
+	if isAdmin(uin) {
+		lists = append(lists, "管理员")
+	}
+	if inBlacklist(uin) {
+		lists = append(lists, "黑名单")
+	}
+	if inWhitelist(uin) {
+		lists = append(lists, "白名单")
+	}
+	if len(lists) == 0 {
+		return ""
+	}
+	return "所在名单：" + strings.Join(lists, "、")
+}
+
+// showTopUsers 查看今天调用次数最多的用户
+func showTopUsers(count int) string {
+	if count <= 0 {
+		count = DefaultTopCount
+	}
+	if count > MaxTopCount {
+		count = MaxTopCount
+	}
+	date := today()
+	dbService := service.NewDBService(database.GetDB())
+	users, err := dbService.GetTopUsers(date, count)
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to get top users.")
+		return DatabaseErrorMessage
+	}
+	total, active, times, err := dbService.GetUsageSummary(date)
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to get usage summary.")
+		return DatabaseErrorMessage
+	}
+	lines := []string{fmt.Sprintf("今日共 %d 次调用，%d / %d 个用户使用过。", times, active, total)}
+	for i, user := range users {
+		lines = append(lines, fmt.Sprintf("%d. %s %d 次", i+1, userDisplayName(user), user.TimesOn(date)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// userDisplayName 用户显示名称，如「小明（10001）」
+func userDisplayName(user model.User) string {
+	if user.Name == "" {
+		return fmt.Sprint(user.Uin)
+	}
+	return fmt.Sprintf("%s（%d）", user.Name, user.Uin)
+}
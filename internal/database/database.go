@@ -17,6 +17,9 @@ type DBInterface interface {
 func InitDatabase(dbi DBInterface) {
 	db, err := dbi.InitDB(
 		&model.User{},
+		&model.GroupSetting{},
+		&model.AccessEntry{},
+		&model.AuditLog{},
 	)
 	if err != nil {
 		panic(err)
@@ -0,0 +1,21 @@
+package model
+
+import "gorm.io/gorm"
+
+// AccessEntry 名单中的号码
+// ListName 为 blacklist、whitelist、allowed 或 admins，Target 为 QQ 号、群号或频道用户 ID
+type AccessEntry struct {
+	gorm.Model
+	ListName string `gorm:"size:16;uniqueIndex:idx_access_list_target"`
+	Target   int64  `gorm:"uniqueIndex:idx_access_list_target"`
+}
+
+// AuditLog 名单修改记录
+type AuditLog struct {
+	gorm.Model
+	Actor    int64  // 操作者，为 0 时表示从配置文件导入
+	Action   string `gorm:"size:16"` // add | remove | import
+	ListName string `gorm:"size:16"`
+	Target   int64
+	Reason   string
+}
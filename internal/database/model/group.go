@@ -0,0 +1,38 @@
+package model
+
+import "gorm.io/gorm"
+
+// GroupSetting 群设置
+// 没有记录的群使用默认设置：除自然语言问答外的功能全部开启，自然语言问答按配置文件
+// GroupCode 为 0 的记录用于私聊和频道共用的调用额度
+type GroupSetting struct {
+	gorm.Model
+	GroupCode       int64  `gorm:"uniqueIndex"`
+	Realtime        bool   `gorm:"default:true"` // 实时天气
+	Nowcast         bool   `gorm:"default:true"` // 出门建议（短时降水）
+	Daily           bool   `gorm:"default:true"` // 今天、明天天气和定时推送
+	NaturalLanguage *bool  // 自然语言问答，为空时按配置文件的 natural_language.groups
+	DailyLimit      int    // 群内每人每日调用次数上限，0 表示使用全局设置
+	Verbosity       string // 输出的详细程度 brief | normal | full，为空时为 full
+	Budget          int    // 群每日调用额度，0 表示使用全局设置，-1 表示不限
+	Times           int    // 今日群内的调用次数
+	ResetDate       string `gorm:"default:''"` // 调用次数所属的日期，与当天不同时调用次数视为 0
+}
+
+// TimesOn date 当天群内的调用次数
+func (g GroupSetting) TimesOn(date string) int {
+	if g.ResetDate != date {
+		return 0
+	}
+	return g.Times
+}
+
+// DefaultGroupSetting 默认群设置
+func DefaultGroupSetting(groupCode int64) GroupSetting {
+	return GroupSetting{
+		GroupCode: groupCode,
+		Realtime:  true,
+		Nowcast:   true,
+		Daily:     true,
+	}
+}
@@ -9,5 +9,16 @@ type User struct {
 	Name      string
 	Longitude float64
 	Latitude  float64
-	Times     int // 调用次数
+	Times     int    // 调用次数
+	Timezone  string // 显示时间使用的时区，为空时使用预报地点的时区
+	Tier      string // 用户等级，为空时为 default
+	ResetDate string `gorm:"default:''"` // 调用次数所属的日期，如 2022-09-01，与当天不同时调用次数视为 0
+}
+
+// TimesOn date 当天的调用次数
+func (u User) TimesOn(date string) int {
+	if u.ResetDate != date {
+		return 0
+	}
+	return u.Times
 }
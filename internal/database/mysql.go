@@ -3,6 +3,7 @@ package database
 import (
 	"fmt"
 
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/pkg"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
@@ -17,6 +18,18 @@ type MysqlDatabase struct {
 	CharSet  string
 }
 
+// String 用于日志输出，隐藏密码
+func (m MysqlDatabase) String() string {
+	m.Password = pkg.Redact(m.Password)
+	type plain MysqlDatabase
+	return fmt.Sprintf("%+v", plain(m))
+}
+
+// GoString 用于 %#v 输出，隐藏密码
+func (m MysqlDatabase) GoString() string {
+	return "database.MysqlDatabase" + m.String()
+}
+
 // InitDB init database
 func (m MysqlDatabase) InitDB(migrateDst ...interface{}) (*gorm.DB, error) {
 	args := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=%s&parseTime=true",
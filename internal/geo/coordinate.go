@@ -0,0 +1,322 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidFormat 坐标格式错误
+var ErrInvalidFormat = errors.New("invalid coordinate format")
+
+// ErrAxisConflict 两个坐标分量被标记为同一种（同为经度或同为纬度）
+var ErrAxisConflict = errors.New("both components are on the same axis")
+
+// RangeError 经纬度超出范围
+type RangeError struct {
+	Latitude bool // true 为纬度，false 为经度
+	Value    float64
+}
+
+func (e *RangeError) Error() string {
+	if e.Latitude {
+		return fmt.Sprintf("latitude %f out of range", e.Value)
+	}
+	return fmt.Sprintf("longitude %f out of range", e.Value)
+}
+
+// Coordinate 坐标
+type Coordinate struct {
+	Longitude float64
+	Latitude  float64
+	Datum     Datum
+}
+
+// WGS84 转换为 WGS-84 坐标
+func (c Coordinate) WGS84() Coordinate {
+	switch c.Datum {
+	case GCJ02:
+		c.Longitude, c.Latitude = gcj02ToWGS84(c.Longitude, c.Latitude)
+	case BD09:
+		c.Longitude, c.Latitude = gcj02ToWGS84(bd09ToGCJ02(c.Longitude, c.Latitude))
+	}
+	c.Datum = WGS84
+	return c
+}
+
+// 中国大致的经纬度范围，用于推断未标注的经纬度顺序
+const (
+	chinaMinLongitude = 73.5
+	chinaMaxLongitude = 135.1
+	chinaMinLatitude  = 3.8
+	chinaMaxLatitude  = 53.6
+)
+
+// datumPattern 可能是坐标系标记的词，是否为坐标系由 ParseDatum 判断
+var datumPattern = regexp.MustCompile(`wgs-?84|gcj-?02|bd-?09|[a-z]+|高德|腾讯|火星|百度`)
+
+// ParseCoordinate 解析坐标字符串
+//
+// 支持的写法：
+//   - 分隔符：空格、逗号、分号（含全角）
+//   - 十进制度：「116.4074 39.9042」「39.9042, 116.4074」
+//   - 度分秒：「39°54'15"N 116°24'27"E」「北纬39度54分15秒 东经116度24分27秒」
+//   - 方位标记：N/S/E/W 前缀或后缀，北纬/南纬/东经/西经，经度/纬度
+//   - 坐标系：附加 wgs84 / gcj02 / 高德 / bd09 / 百度 等标记，默认为 WGS-84
+//
+// 未标注方位时按经度在前处理，除非数值明显是「纬度 经度」的顺序（按中国范围推断）。
+// 返回的坐标保留原始坐标系，需要时调用 Coordinate.WGS84 转换。
+func ParseCoordinate(s string) (Coordinate, error) {
+	var c Coordinate
+	// 坐标系标记可能紧跟在数字后面，如「116.4133,39.9110高德」，先全部去掉再解析
+	rest := datumPattern.ReplaceAllStringFunc(normalize(s), func(word string) string {
+		if datum, ok := ParseDatum(word); ok {
+			c.Datum = datum
+			return " "
+		}
+		return word
+	})
+	components, err := scanComponents(strings.TrimSpace(rest))
+	if err != nil {
+		return c, err
+	}
+	if len(components) != 2 {
+		return c, ErrInvalidFormat
+	}
+	c.Longitude, c.Latitude, err = resolveAxes(components[0], components[1])
+	if err != nil {
+		return c, err
+	}
+	if c.Longitude < -180.0 || c.Longitude > 180.0 {
+		return c, &RangeError{Latitude: false, Value: c.Longitude}
+	}
+	if c.Latitude < -90.0 || c.Latitude > 90.0 {
+		return c, &RangeError{Latitude: true, Value: c.Latitude}
+	}
+	return c, nil
+}
+
+// normalize 统一全角符号和大小写
+func normalize(s string) string {
+	replacer := strings.NewReplacer(
+		"，", ",",
+		"；", ";",
+		"　", " ",
+		"＋", "+",
+		"－", "-",
+		"．", ".",
+		"’", "'",
+		"＇", "'",
+		"”", "\"",
+		"＂", "\"",
+		"''", "\"",
+		"′′", "″",
+	)
+	return strings.ToLower(strings.TrimSpace(replacer.Replace(s)))
+}
+
+// axis 坐标分量所属的轴
+type axis int
+
+const (
+	axisUnknown axis = iota
+	axisLongitude
+	axisLatitude
+)
+
+// component 一个坐标分量，如「39°54'15"N」
+type component struct {
+	degrees  float64
+	minutes  float64
+	seconds  float64
+	negative bool
+	axis     axis
+	hasMin   bool
+	hasSec   bool
+}
+
+func (c component) value() float64 {
+	v := c.degrees + c.minutes/60.0 + c.seconds/3600.0
+	if c.negative {
+		return -v
+	}
+	return v
+}
+
+// marker 方位标记
+type marker struct {
+	word     string
+	axis     axis
+	negative bool
+}
+
+// markers 按长度排序，先匹配较长的标记
+var markers = []marker{
+	{"北纬", axisLatitude, false},
+	{"南纬", axisLatitude, true},
+	{"东经", axisLongitude, false},
+	{"西经", axisLongitude, true},
+	{"经度", axisLongitude, false},
+	{"纬度", axisLatitude, false},
+	{"n", axisLatitude, false},
+	{"s", axisLatitude, true},
+	{"e", axisLongitude, false},
+	{"w", axisLongitude, true},
+}
+
+// scanComponents 将字符串切分为坐标分量
+func scanComponents(s string) ([]component, error) {
+	var components []component
+	var current *component
+	var pending *marker // 等待后面数字的前缀标记
+	finish := func() {
+		if current != nil {
+			components = append(components, *current)
+			current = nil
+		}
+	}
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r) || r == ':' || r == '：':
+			i++
+		case r == ',' || r == ';' || r == '/':
+			if pending != nil {
+				return nil, ErrInvalidFormat
+			}
+			finish()
+			i++
+		case unicode.IsDigit(r) || r == '.' || r == '-' || r == '+':
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			number, err := strconv.ParseFloat(string(runes[i:j]), 64)
+			if err != nil {
+				return nil, ErrInvalidFormat
+			}
+			// 跳过数字与单位之间的空格
+			k := j
+			for k < len(runes) && runes[k] == ' ' {
+				k++
+			}
+			unit := rune(0)
+			if k < len(runes) {
+				switch runes[k] {
+				case '°', '度', 'º':
+					unit = '°'
+				case '\'', '′', '分':
+					unit = '\''
+				case '"', '″', '秒':
+					unit = '"'
+				}
+			}
+			if unit != 0 {
+				j = k + 1
+			}
+			switch unit {
+			case 0, '°':
+				finish()
+				current = &component{degrees: math.Abs(number), negative: number < 0}
+				if pending != nil {
+					current.axis = pending.axis
+					current.negative = current.negative != pending.negative
+					pending = nil
+				}
+			case '\'':
+				if current == nil || current.hasMin || current.hasSec || number < 0 || number >= 60 {
+					return nil, ErrInvalidFormat
+				}
+				current.minutes = number
+				current.hasMin = true
+			case '"':
+				if current == nil || current.hasSec || number < 0 || number >= 60 {
+					return nil, ErrInvalidFormat
+				}
+				current.seconds = number
+				current.hasSec = true
+			}
+			i = j
+		default:
+			m := matchMarker(runes[i:])
+			if m == nil {
+				return nil, ErrInvalidFormat
+			}
+			if current != nil && current.axis == axisUnknown && pending == nil {
+				// 后缀标记
+				current.axis = m.axis
+				current.negative = current.negative != m.negative
+			} else {
+				if pending != nil {
+					return nil, ErrInvalidFormat
+				}
+				finish()
+				pending = m
+			}
+			i += len([]rune(m.word))
+		}
+	}
+	if pending != nil {
+		return nil, ErrInvalidFormat
+	}
+	finish()
+	return components, nil
+}
+
+func matchMarker(runes []rune) *marker {
+	for i := range markers {
+		word := []rune(markers[i].word)
+		if len(runes) < len(word) || string(runes[:len(word)]) != markers[i].word {
+			continue
+		}
+		// 单字母标记后面不能紧跟字母，避免把其他单词当作方位
+		if len(word) == 1 && len(runes) > 1 && unicode.IsLetter(runes[1]) && runes[1] < unicode.MaxASCII {
+			return nil
+		}
+		return &markers[i]
+	}
+	return nil
+}
+
+// resolveAxes 确定两个分量中哪个是经度、哪个是纬度
+func resolveAxes(a, b component) (longitude, latitude float64, err error) {
+	switch {
+	case a.axis != axisUnknown && b.axis != axisUnknown:
+		if a.axis == b.axis {
+			return 0, 0, ErrAxisConflict
+		}
+		if a.axis == axisLatitude {
+			return b.value(), a.value(), nil
+		}
+		return a.value(), b.value(), nil
+	case a.axis == axisLatitude || b.axis == axisLongitude:
+		return b.value(), a.value(), nil
+	case a.axis == axisLongitude || b.axis == axisLatitude:
+		return a.value(), b.value(), nil
+	}
+	x, y := a.value(), b.value()
+	switch {
+	case math.Abs(x) > 90.0 && math.Abs(y) <= 90.0:
+		return x, y, nil
+	case math.Abs(y) > 90.0 && math.Abs(x) <= 90.0:
+		return y, x, nil
+	case inChinaLongitude(x) && inChinaLatitude(y):
+		return x, y, nil
+	case inChinaLatitude(x) && inChinaLongitude(y):
+		return y, x, nil
+	}
+	return x, y, nil
+}
+
+func inChinaLongitude(v float64) bool {
+	return v >= chinaMinLongitude && v <= chinaMaxLongitude
+}
+
+func inChinaLatitude(v float64) bool {
+	return v >= chinaMinLatitude && v <= chinaMaxLatitude
+}
@@ -0,0 +1,115 @@
+package geo
+
+import (
+	"math"
+	"strings"
+)
+
+// Datum 坐标系
+type Datum int
+
+const (
+	// WGS84 GPS 使用的国际标准坐标系
+	WGS84 Datum = iota
+	// GCJ02 国测局坐标系（火星坐标系），高德、腾讯地图使用
+	GCJ02
+	// BD09 百度地图使用的坐标系
+	BD09
+)
+
+// String 坐标系名称
+func (d Datum) String() string {
+	switch d {
+	case GCJ02:
+		return "GCJ-02"
+	case BD09:
+		return "BD-09"
+	default:
+		return "WGS-84"
+	}
+}
+
+// ParseDatum 解析坐标系名称
+// 支持 wgs84 / gps、gcj02 / 高德 / 腾讯 / 火星、bd09 / 百度 等写法
+func ParseDatum(s string) (Datum, bool) {
+	switch strings.ToLower(s) {
+	case "wgs84", "wgs-84", "gps":
+		return WGS84, true
+	case "gcj02", "gcj-02", "gcj", "amap", "高德", "腾讯", "火星":
+		return GCJ02, true
+	case "bd09", "bd-09", "bd", "baidu", "百度":
+		return BD09, true
+	}
+	return WGS84, false
+}
+
+const (
+	krasovskyA  = 6378245.0              // 克拉索夫斯基椭球长半轴
+	krasovskyEE = 0.00669342162296594323 // 克拉索夫斯基椭球第一偏心率平方
+	bdXPi       = math.Pi * 3000.0 / 180.0
+)
+
+// outOfChina 粗略判断坐标是否在国内，国外坐标不做偏移
+func outOfChina(longitude, latitude float64) bool {
+	return longitude < 72.004 || longitude > 137.8347 || latitude < 0.8293 || latitude > 55.8271
+}
+
+// wgs84ToGCJ02 WGS-84 转 GCJ-02
+func wgs84ToGCJ02(longitude, latitude float64) (float64, float64) {
+	if outOfChina(longitude, latitude) {
+		return longitude, latitude
+	}
+	dLat := transformLatitude(longitude-105.0, latitude-35.0)
+	dLon := transformLongitude(longitude-105.0, latitude-35.0)
+	radLat := latitude / 180.0 * math.Pi
+	magic := math.Sin(radLat)
+	magic = 1 - krasovskyEE*magic*magic
+	sqrtMagic := math.Sqrt(magic)
+	dLat = (dLat * 180.0) / ((krasovskyA * (1 - krasovskyEE)) / (magic * sqrtMagic) * math.Pi)
+	dLon = (dLon * 180.0) / (krasovskyA / sqrtMagic * math.Cos(radLat) * math.Pi)
+	return longitude + dLon, latitude + dLat
+}
+
+// gcj02ToWGS84 GCJ-02 转 WGS-84
+// GCJ-02 没有解析解，这里迭代逼近，精度约 1e-7 度
+func gcj02ToWGS84(longitude, latitude float64) (float64, float64) {
+	if outOfChina(longitude, latitude) {
+		return longitude, latitude
+	}
+	wgsLon, wgsLat := longitude, latitude
+	for i := 0; i < 30; i++ {
+		gcjLon, gcjLat := wgs84ToGCJ02(wgsLon, wgsLat)
+		dLon, dLat := longitude-gcjLon, latitude-gcjLat
+		wgsLon += dLon
+		wgsLat += dLat
+		if math.Abs(dLon) < 1e-7 && math.Abs(dLat) < 1e-7 {
+			break
+		}
+	}
+	return wgsLon, wgsLat
+}
+
+// bd09ToGCJ02 BD-09 转 GCJ-02
+func bd09ToGCJ02(longitude, latitude float64) (float64, float64) {
+	x := longitude - 0.0065
+	y := latitude - 0.006
+	z := math.Sqrt(x*x+y*y) - 0.00002*math.Sin(y*bdXPi)
+	theta := math.Atan2(y, x) - 0.000003*math.Cos(x*bdXPi)
+	return z * math.Cos(theta), z * math.Sin(theta)
+}
+
+func transformLatitude(x, y float64) float64 {
+	ret := -100.0 + 2.0*x + 3.0*y + 0.2*y*y + 0.1*x*y + 0.2*math.Sqrt(math.Abs(x))
+	ret += (20.0*math.Sin(6.0*x*math.Pi) + 20.0*math.Sin(2.0*x*math.Pi)) * 2.0 / 3.0
+	ret += (20.0*math.Sin(y*math.Pi) + 40.0*math.Sin(y/3.0*math.Pi)) * 2.0 / 3.0
+	ret += (160.0*math.Sin(y/12.0*math.Pi) + 320*math.Sin(y*math.Pi/30.0)) * 2.0 / 3.0
+	return ret
+}
+
+func transformLongitude(x, y float64) float64 {
+	ret := 300.0 + x + 2.0*y + 0.1*x*x + 0.1*x*y + 0.1*math.Sqrt(math.Abs(x))
+	ret += (20.0*math.Sin(6.0*x*math.Pi) + 20.0*math.Sin(2.0*x*math.Pi)) * 2.0 / 3.0
+	ret += (20.0*math.Sin(x*math.Pi) + 40.0*math.Sin(x/3.0*math.Pi)) * 2.0 / 3.0
+	ret += (150.0*math.Sin(x/12.0*math.Pi) + 300.0*math.Sin(x/30.0*math.Pi)) * 2.0 / 3.0
+	return ret
+}
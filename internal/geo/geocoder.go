@@ -0,0 +1,388 @@
+package geo
+
+import (
+	"bytes"
+	_ "embed" // 内置行政区划数据
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed data/regions.csv
+var embeddedRegions []byte
+
+// 按代表点匹配时的距离限制（千米）
+// 代表点数据没有边界，按与最近的同级代表点的距离估计行政区的大小：城区的区县很小，西部的地级市很大
+// 每个代表点只匹配 nearestReachRatio 倍间距以内的坐标，并限制在该级别的上下限之间
+const (
+	MinDistrictDistance float64 = 15.0
+	MaxDistrictDistance float64 = 30.0
+	MinCityDistance     float64 = 30.0
+	MaxCityDistance     float64 = 150.0
+	nearestReachRatio   float64 = 0.6
+)
+
+// Region 行政区
+type Region struct {
+	Province  string
+	City      string
+	District  string
+	Longitude float64 // 代表点经度
+	Latitude  float64 // 代表点纬度
+
+	reach    float64          // 没有边界时按代表点匹配的最大距离（千米），见 estimateReach
+	polygons [][][][2]float64 // 边界，polygon -> ring -> point，第一个 ring 为外环
+}
+
+// Label 地名标签，如「北京市 海淀区」「河北省 保定市」
+func (r Region) Label() string {
+	var parts []string
+	for _, part := range []string{r.Province, r.City, r.District} {
+		if part != "" && (len(parts) == 0 || parts[len(parts)-1] != part) {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// level 行政级别，数值越大越精确
+func (r Region) level() int {
+	switch {
+	case r.District != "":
+		return 3
+	case r.City != "":
+		return 2
+	default:
+		return 1
+	}
+}
+
+// Geocoder 离线地理编码
+type Geocoder struct {
+	regions []Region
+	names   map[string]int // 地名（全称和简称）到 regions 下标
+}
+
+var defaultGeocoder *Geocoder
+var defaultGeocoderOnce sync.Once
+
+// DefaultGeocoder 使用内置数据的逆地理编码器
+// 内置数据只有各行政区的代表点，只能匹配代表点附近的坐标，需要精确结果时通过 LoadGeocoder 加载边界数据
+func DefaultGeocoder() *Geocoder {
+	defaultGeocoderOnce.Do(func() {
+		g, err := ParseCSV(bytes.NewReader(embeddedRegions))
+		if err != nil {
+			panic(err)
+		}
+		defaultGeocoder = g
+	})
+	return defaultGeocoder
+}
+
+// LoadGeocoder 从文件加载逆地理编码数据
+// .csv 文件格式与内置数据相同；.json / .geojson 文件为 GeoJSON FeatureCollection，
+// 要素的 properties 需包含 province、city、district（可缺省），几何类型支持 Point、Polygon、MultiPolygon
+func LoadGeocoder(path string) (*Geocoder, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return ParseCSV(bytes.NewReader(data))
+	case ".json", ".geojson":
+		return ParseGeoJSON(data)
+	}
+	return nil, fmt.Errorf("unsupported geocoder data file: %s", path)
+}
+
+// ParseCSV 解析 CSV 格式的行政区代表点数据
+func ParseCSV(r io.Reader) (*Geocoder, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 5
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	g := &Geocoder{}
+	for i, record := range records {
+		longitude, err := strconv.ParseFloat(record[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: invalid longitude %q", i+1, record[3])
+		}
+		latitude, err := strconv.ParseFloat(record[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: invalid latitude %q", i+1, record[4])
+		}
+		g.regions = append(g.regions, Region{
+			Province:  record[0],
+			City:      record[1],
+			District:  record[2],
+			Longitude: longitude,
+			Latitude:  latitude,
+		})
+	}
+	g.buildIndex()
+	g.estimateReach()
+	return g, nil
+}
+
+// estimateReach 估计每个代表点的匹配范围
+// 取与最近的同级代表点距离的 nearestReachRatio 倍，同级只有一个代表点时使用该级别的上限
+func (g *Geocoder) estimateReach() {
+	for i := range g.regions {
+		r := &g.regions[i]
+		if len(r.polygons) > 0 {
+			continue
+		}
+		lower, upper := MinCityDistance, MaxCityDistance
+		if r.level() == 3 {
+			lower, upper = MinDistrictDistance, MaxDistrictDistance
+		}
+		nearest := math.Inf(1)
+		for j, other := range g.regions {
+			if j == i || len(other.polygons) > 0 || other.level() != r.level() {
+				continue
+			}
+			nearest = math.Min(nearest, Distance(r.Longitude, r.Latitude, other.Longitude, other.Latitude))
+		}
+		r.reach = math.Max(lower, math.Min(upper, nearest*nearestReachRatio))
+	}
+}
+
+type geoJSONFeatureCollection struct {
+	Features []struct {
+		Properties struct {
+			Province string `json:"province"`
+			City     string `json:"city"`
+			District string `json:"district"`
+		} `json:"properties"`
+		Geometry struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+	} `json:"features"`
+}
+
+// ParseGeoJSON 解析 GeoJSON 格式的行政区边界数据
+func ParseGeoJSON(data []byte) (*Geocoder, error) {
+	var collection geoJSONFeatureCollection
+	if err := json.Unmarshal(data, &collection); err != nil {
+		return nil, err
+	}
+	g := &Geocoder{}
+	for i, feature := range collection.Features {
+		region := Region{
+			Province: feature.Properties.Province,
+			City:     feature.Properties.City,
+			District: feature.Properties.District,
+		}
+		var err error
+		switch feature.Geometry.Type {
+		case "Point":
+			var point [2]float64
+			err = json.Unmarshal(feature.Geometry.Coordinates, &point)
+			region.Longitude, region.Latitude = point[0], point[1]
+		case "Polygon":
+			var polygon [][][2]float64
+			err = json.Unmarshal(feature.Geometry.Coordinates, &polygon)
+			region.polygons = [][][][2]float64{polygon}
+		case "MultiPolygon":
+			err = json.Unmarshal(feature.Geometry.Coordinates, &region.polygons)
+		default:
+			err = fmt.Errorf("unsupported geometry type %q", feature.Geometry.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("feature %d: %w", i, err)
+		}
+		if feature.Geometry.Type != "Point" {
+			if len(region.polygons) == 0 || len(region.polygons[0]) == 0 {
+				// 没有任何环的边界无法匹配，跳过
+				continue
+			}
+			region.Longitude, region.Latitude = ringCenter(region.polygons[0][0])
+		}
+		g.regions = append(g.regions, region)
+	}
+	g.buildIndex()
+	g.estimateReach()
+	return g, nil
+}
+
+// nameSuffixes 行政区划名称后缀，去掉后得到简称
+var nameSuffixes = []string{"特别行政区", "维吾尔自治区", "壮族自治区", "回族自治区", "自治区", "自治州", "自治县", "地区", "林区", "新区", "省", "市", "区", "县", "盟"}
+
+// ethnicNames 自治地方名称中的民族名称，去掉后得到简称，如「延边朝鲜族自治州」简称「延边」
+var ethnicNames = []string{"土家族", "苗族", "朝鲜族", "蒙古族", "藏族", "羌族", "彝族", "白族", "哈尼族", "壮族", "布依族", "侗族", "傣族", "景颇族", "傈僳族", "回族", "柯尔克孜", "哈萨克", "维吾尔"}
+
+// shortName 行政区划简称，如「北京市」简称「北京」
+func shortName(name string) string {
+	for _, suffix := range nameSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			name = strings.TrimSuffix(name, suffix)
+			break
+		}
+	}
+	for trimmed := true; trimmed; {
+		trimmed = false
+		for _, ethnic := range ethnicNames {
+			if strings.HasSuffix(name, ethnic) {
+				name = strings.TrimSuffix(name, ethnic)
+				trimmed = true
+			}
+		}
+	}
+	return name
+}
+
+// buildIndex 建立地名索引
+// 名称冲突时级别高的优先，如「河北」指河北省而不是天津市河北区；省级名称指向省内第一个行政区（一般为省会）
+func (g *Geocoder) buildIndex() {
+	g.names = make(map[string]int)
+	add := func(name string, index int) {
+		if name == "" {
+			return
+		}
+		for _, n := range []string{name, shortName(name)} {
+			if _, ok := g.names[n]; !ok && len([]rune(n)) >= 2 {
+				g.names[n] = index
+			}
+		}
+	}
+	for level := 1; level <= 3; level++ {
+		for i, region := range g.regions {
+			switch level {
+			case 1:
+				add(region.Province, i)
+			case 2:
+				add(region.City, i)
+			case 3:
+				add(region.District, i)
+			}
+		}
+	}
+}
+
+// Forward 地理编码，按地名查找行政区
+// 支持全称和简称，如「北京市」「北京」「海淀区」「海淀」
+func (g *Geocoder) Forward(name string) (Region, bool) {
+	index, ok := g.names[name]
+	if !ok {
+		return Region{}, false
+	}
+	region := g.regions[index]
+	// 只按省份名称查询时，只保留省份，避免标注成省会
+	if name == region.Province || name == shortName(region.Province) {
+		region.City, region.District = "", ""
+	}
+	return region, true
+}
+
+// FindPlace 在文本中查找最长的地名，长度相同时取字典序较小的，保证结果稳定
+func (g *Geocoder) FindPlace(text string) (string, bool) {
+	found := ""
+	for name := range g.names {
+		if !strings.Contains(text, name) {
+			continue
+		}
+		if len(name) > len(found) || (len(name) == len(found) && name < found) {
+			found = name
+		}
+	}
+	return found, found != ""
+}
+
+// Reverse 逆地理编码
+// 优先使用边界数据判断所在行政区，没有边界数据时按最近的代表点匹配，只匹配国内、在代表点估计范围内的坐标
+func (g *Geocoder) Reverse(longitude, latitude float64) (Region, bool) {
+	var found Region
+	ok := false
+	for _, region := range g.regions {
+		if len(region.polygons) == 0 || (ok && region.level() <= found.level()) {
+			continue
+		}
+		if region.contains(longitude, latitude) {
+			found, ok = region, true
+		}
+	}
+	if ok {
+		return found, true
+	}
+	if outOfChina(longitude, latitude) {
+		return found, false
+	}
+	minDistance := math.Inf(1)
+	for _, region := range g.regions {
+		if len(region.polygons) > 0 {
+			continue
+		}
+		if d := Distance(longitude, latitude, region.Longitude, region.Latitude); d <= region.reach && d < minDistance {
+			found, ok, minDistance = region, true, d
+		}
+	}
+	return found, ok
+}
+
+// contains 点是否在行政区边界内
+func (r Region) contains(longitude, latitude float64) bool {
+	for _, polygon := range r.polygons {
+		if len(polygon) == 0 || !ringContains(polygon[0], longitude, latitude) {
+			continue
+		}
+		inHole := false
+		for _, hole := range polygon[1:] {
+			if ringContains(hole, longitude, latitude) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// ringContains 射线法判断点是否在环内
+func ringContains(ring [][2]float64, x, y float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// ringCenter 环上各点的平均位置，作为代表点
+func ringCenter(ring [][2]float64) (float64, float64) {
+	if len(ring) == 0 {
+		return 0, 0
+	}
+	var x, y float64
+	for _, p := range ring {
+		x += p[0]
+		y += p[1]
+	}
+	return x / float64(len(ring)), y / float64(len(ring))
+}
+
+// Distance 两点间的球面距离（千米）
+func Distance(lon1, lat1, lon2, lat2 float64) float64 {
+	const earthRadius = 6371.0
+	rad := math.Pi / 180.0
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
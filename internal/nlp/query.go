@@ -0,0 +1,329 @@
+package nlp
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Aspect 问题关注的天气要素
+type Aspect int
+
+const (
+	AspectGeneral     Aspect = iota // 天气怎么样
+	AspectRain                      // 会下雨吗、要带伞吗
+	AspectSnow                      // 会下雪吗
+	AspectTemperature               // 冷不冷、多少度
+	AspectWind                      // 风大吗
+	AspectAir                       // 空气好吗、有雾霾吗
+)
+
+// Query 解析出的天气问题
+type Query struct {
+	Day      int    // 起始日期，相对今天的天数
+	Days     int    // 覆盖的天数，至少为 1
+	FromHour int    // 当地时间的起始小时，-1 表示全天
+	ToHour   int    // 当地时间的结束小时（不含）
+	Location string // 问题中提到的地名，为空时使用用户保存的地址
+	Aspect   Aspect
+	TimeText string // 时间描述，如「明天下午」「周末」
+
+	// 以下为相对当前时间的表达，Day、FromHour 等需要按预报地点的当地时间计算，见 Resolve
+	Weekday  int  // 问题中的星期几，1~7（周一到周日），0 表示没有
+	Weekend  bool // 问题中提到了周末
+	NextWeek bool // 「下周三」「下周末」
+	Current  bool // 「现在」「一会」
+}
+
+// PlaceFinder 在文本中查找地名
+type PlaceFinder interface {
+	FindPlace(text string) (string, bool)
+}
+
+// MaxLength 超过这个长度的消息不当作天气问题
+const MaxLength int = 30
+
+// aspectKeywords 要素关键词，按顺序匹配，靠前的优先
+var aspectKeywords = []struct {
+	aspect   Aspect
+	keywords []string
+}{
+	{AspectSnow, []string{"下雪", "有雪", "雪"}},
+	{AspectRain, []string{"下雨", "有雨", "带伞", "打伞", "雨"}},
+	{AspectTemperature, []string{"冷", "热", "温度", "气温", "几度", "多少度", "穿什么", "穿多少"}},
+	{AspectWind, []string{"风"}},
+	{AspectAir, []string{"空气", "雾霾", "霾", "污染", "aqi"}},
+	{AspectGeneral, []string{"天气", "晴"}},
+}
+
+// questionMarkers 疑问标记
+// 「不」「没」只在「冷不冷」「有没有」这样的正反问中算作疑问，见 hasAnotA
+var questionMarkers = []string{"吗", "嘛", "么", "怎么样", "怎样", "如何", "多少", "几", "呢", "?", "？"}
+
+// periods 时段与对应的当地小时范围
+var periods = []struct {
+	word     string
+	from, to int
+}{
+	{"凌晨", 0, 6},
+	{"清晨", 6, 9},
+	{"早晨", 6, 9},
+	{"早上", 6, 9},
+	{"上午", 9, 12},
+	{"中午", 12, 14},
+	{"下午", 14, 18},
+	{"傍晚", 17, 19},
+	{"晚上", 18, 24},
+	{"夜里", 18, 24},
+	{"夜间", 18, 24},
+	{"白天", 8, 20},
+}
+
+// dayWords 日期词，较长的词在前
+var dayWords = []struct {
+	word     string
+	day      int
+	from, to int // -1 表示不限定时段
+}{
+	{"大后天", 3, -1, -1},
+	{"后天", 2, -1, -1},
+	{"明天", 1, -1, -1},
+	{"明日", 1, -1, -1},
+	{"明早", 1, 6, 9},
+	{"明晚", 1, 18, 24},
+	{"今天", 0, -1, -1},
+	{"今日", 0, -1, -1},
+	{"今早", 0, 6, 9},
+	{"今晚", 0, 18, 24},
+	{"今夜", 0, 18, 24},
+}
+
+var weekdayPattern = regexp.MustCompile(`(下|这|本)?(周|星期|礼拜)([一二三四五六日天1-7])`)
+var weekendPattern = regexp.MustCompile(`(下|这|本)?周末`)
+var clockPattern = regexp.MustCompile(`([0-9]{1,2}|[零一二两三四五六七八九十]{1,3})(点|时)(半)?|([0-9]{1,2})[:：]([0-9]{2})`)
+
+// Parse 解析天气问题
+// now 为当前时间，用于计算星期几等相对日期，知道预报地点的当地时间后应使用 Resolve 重新计算；不像天气问题时返回 false
+// 为减少误触发，问题需要同时包含天气要素和疑问语气，并且提到时间、地点或「天气」之一
+func Parse(text string, now time.Time, places PlaceFinder) (Query, bool) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	q := Query{Days: 1, FromHour: -1, ToHour: -1}
+	if text == "" || len([]rune(text)) > MaxLength {
+		return q, false
+	}
+	aspect, ok := parseAspect(text)
+	if !ok || !(containsAny(text, questionMarkers) || hasAnotA(text)) {
+		return q, false
+	}
+	q.Aspect = aspect
+	if places != nil {
+		q.Location, _ = places.FindPlace(text)
+	}
+	// 去掉地名再识别时间，避免「今晚上海」被识别出「晚上」
+	timeText := text
+	if q.Location != "" {
+		timeText = strings.Replace(text, q.Location, " ", 1)
+	}
+	hasTime := parseTime(timeText, &q)
+	q = q.Resolve(now)
+	if !hasTime && q.Location == "" && !strings.Contains(text, "天气") {
+		return q, false
+	}
+	if q.TimeText == "" {
+		q.TimeText = "今天"
+	}
+	return q, true
+}
+
+// parseAspect 识别问题关注的天气要素
+func parseAspect(text string) (Aspect, bool) {
+	for _, v := range aspectKeywords {
+		if containsAny(text, v.keywords) {
+			return v.aspect, true
+		}
+	}
+	return AspectGeneral, false
+}
+
+// Resolve 按 now 计算星期几、周末和「现在」对应的日期和时段，now 应为预报地点的当地时间
+// 「今天」「明天」等只与日期差有关，不需要重新计算
+func (q Query) Resolve(now time.Time) Query {
+	switch {
+	case q.Weekend:
+		q.Day, q.Days = weekendOffset(now, q.NextWeek)
+	case q.Weekday > 0:
+		q.Day = weekdayOffset(now, q.Weekday, q.NextWeek)
+	}
+	if q.Current {
+		q.Day = 0
+		q.FromHour, q.ToHour = now.Hour(), now.Hour()+2
+		if q.ToHour > 24 {
+			q.ToHour = 24
+		}
+	}
+	return q
+}
+
+// parseTime 识别时间表达，没有时间表达时返回 false
+// 星期几、周末和「现在」只记录在 q 中，由 Resolve 计算具体日期
+func parseTime(text string, q *Query) bool {
+	found := false
+	var parts []string
+	for _, v := range dayWords {
+		if strings.Contains(text, v.word) {
+			q.Day = v.day
+			if v.from >= 0 {
+				q.FromHour, q.ToHour = v.from, v.to
+			}
+			parts = append(parts, v.word)
+			found = true
+			break
+		}
+	}
+	if !found {
+		if m := weekendPattern.FindStringSubmatch(text); m != nil {
+			q.Weekend, q.NextWeek = true, m[1] == "下"
+			parts = append(parts, m[0])
+			found = true
+		} else if m := weekdayPattern.FindStringSubmatch(text); m != nil {
+			q.Weekday, q.NextWeek = weekdayNumber(m[3]), m[1] == "下"
+			parts = append(parts, m[0])
+			found = true
+		}
+	}
+	afternoon := q.FromHour >= 12
+	for _, v := range periods {
+		if q.FromHour >= 0 {
+			break
+		}
+		if strings.Contains(text, v.word) {
+			q.FromHour, q.ToHour = v.from, v.to
+			afternoon = v.from >= 12
+			parts = append(parts, v.word)
+			found = true
+			break
+		}
+	}
+	if loc := clockPattern.FindStringSubmatchIndex(text); loc != nil && !strings.HasPrefix(text[loc[1]:], "点") {
+		m := clockPattern.FindStringSubmatch(text)
+		hour := -1
+		if m[4] != "" {
+			hour, _ = strconv.Atoi(m[4])
+		} else {
+			hour = chineseNumber(m[1])
+		}
+		if hour >= 0 && hour < 24 {
+			// 没有说明时段时，「三点」一般指下午
+			if (afternoon || q.FromHour >= 12 || (q.FromHour < 0 && hour >= 1 && hour <= 5)) && hour < 12 {
+				hour += 12
+			}
+			q.FromHour, q.ToHour = hour, hour+1
+			parts = append(parts, m[0])
+			found = true
+		}
+	}
+	if strings.Contains(text, "现在") || strings.Contains(text, "一会") || strings.Contains(text, "等会") {
+		q.Current = true
+		parts = append(parts, "现在")
+		found = true
+	}
+	q.TimeText = strings.Join(parts, "")
+	return found
+}
+
+// weekendOffset 周末相对今天的天数和周末剩余的天数
+func weekendOffset(now time.Time, next bool) (int, int) {
+	isoWeekday := int(now.Weekday())
+	if isoWeekday == 0 {
+		isoWeekday = 7
+	}
+	if next {
+		return 6 - isoWeekday + 7, 2
+	}
+	if isoWeekday == 7 {
+		return 0, 1
+	}
+	return 6 - isoWeekday, 2
+}
+
+// weekdayOffset 星期几相对今天的天数，weekday 取值 1~7（周一到周日）
+// 「周三」指本周三，已经过去时指下周三；「下周三」指下一个自然周的周三
+func weekdayOffset(now time.Time, weekday int, next bool) int {
+	isoWeekday := int(now.Weekday())
+	if isoWeekday == 0 {
+		isoWeekday = 7
+	}
+	offset := weekday - isoWeekday
+	if next {
+		return offset + 7
+	}
+	if offset < 0 {
+		offset += 7
+	}
+	return offset
+}
+
+// weekdayNumber 星期几的数字，周一为 1，周日为 7
+func weekdayNumber(s string) int {
+	switch s {
+	case "日", "天", "7":
+		return 7
+	}
+	if n := chineseNumber(s); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// chineseNumber 解析 0~99 的阿拉伯数字或中文数字，解析失败返回 -1
+func chineseNumber(s string) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	digits := map[rune]int{'零': 0, '一': 1, '二': 2, '两': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+	runes := []rune(s)
+	switch {
+	case len(runes) == 1 && runes[0] == '十':
+		return 10
+	case len(runes) == 1:
+		if n, ok := digits[runes[0]]; ok {
+			return n
+		}
+	case len(runes) == 2 && runes[0] == '十':
+		if n, ok := digits[runes[1]]; ok {
+			return 10 + n
+		}
+	case len(runes) == 2 && runes[1] == '十':
+		if n, ok := digits[runes[0]]; ok {
+			return n * 10
+		}
+	case len(runes) == 3 && runes[1] == '十':
+		tens, ok1 := digits[runes[0]]
+		ones, ok2 := digits[runes[2]]
+		if ok1 && ok2 {
+			return tens*10 + ones
+		}
+	}
+	return -1
+}
+
+// hasAnotA 是否包含「冷不冷」「有没有」「会不会」这样的正反问
+func hasAnotA(text string) bool {
+	runes := []rune(text)
+	for i := 0; i+2 < len(runes); i++ {
+		if (runes[i+1] == '不' || runes[i+1] == '没') && runes[i] == runes[i+2] {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(text string, words []string) bool {
+	for _, word := range words {
+		if strings.Contains(text, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,12 @@
+package pkg
+
+// RedactedText 日志中代替密钥显示的文本
+const RedactedText string = "******"
+
+// Redact 隐藏密钥，为空时依然返回空字符串，便于在日志中看出是否填写
+func Redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return RedactedText
+}
@@ -0,0 +1,311 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/nlp"
+)
+
+// MaxForecastDays 小时级别预报最多覆盖的天数
+const MaxForecastDays int = 15
+
+// beaufortSpeeds 蒲福风级的风速下限(km/h)，下标 + 1 为对应的风级
+var beaufortSpeeds = [...]float64{1, 6, 12, 20, 29, 39, 50, 62, 75, 89, 103, 118}
+
+// hourForecast 一小时的预报
+type hourForecast struct {
+	time                time.Time
+	skycon              string
+	temperature         float64
+	apparentTemperature float64
+	precipitation       float64 // 降水强度(mm/hr)
+	probability         int     // 降水概率(%)
+	windSpeed           float64
+	windDirection       float64
+	aqi                 int
+}
+
+// Answer 回答自然语言天气问题
+// 使用小时级别预报，按问题的日期和时段筛选后只回答问题关心的要素
+func (c *Caiyun) Answer(longitude, latitude float64, q nlp.Query) (string, error) {
+	if q.Day+q.Days > MaxForecastDays {
+		return fmt.Sprintf("只能查询未来 %d 天内的天气。", MaxForecastDays), nil
+	}
+	url := fmt.Sprintf("%s/%s/%s/%f,%f/hourly", CaiyunAPIUrl, CaiyunAPIVersion, c.APIKey, longitude, latitude)
+	var hourlyResponse CaiyunAPIHourlyResponse
+	responseBody, err := c.get(url, [][]string{
+		{"hourlysteps", fmt.Sprint(hourlySteps(q))},
+		{"unit", "metric:v2"},
+		{"lang", "zh_CN"},
+	})
+	if err != nil {
+		return "网络错误", err
+	}
+	if err := json.Unmarshal(responseBody, &hourlyResponse); err != nil {
+		return "json 解析错误", err
+	}
+	if hourlyResponse.Status != "ok" {
+		return "api 错误", fmt.Errorf("caiyun api error")
+	}
+	hourly := hourlyResponse.Result.Hourly
+	if hourly.Status != "ok" {
+		return "hourly api 错误", fmt.Errorf("caiyun hourly error")
+	}
+	locationZone := LocationZone(hourlyResponse.Timezone, hourlyResponse.Tzshift)
+	displayZone := c.displayZone(locationZone)
+	localNow := time.Unix(int64(hourlyResponse.ServerTime), 0).In(locationZone)
+	q = q.Resolve(localNow)
+	displayNow := localNow.In(displayZone)
+	hours, err := selectHours(hourlyResponse, localNow, q)
+	if err != nil {
+		return "hourly api 错误", err
+	}
+	origin := c.zoneNote(displayZone) + "信息来源：彩云天气"
+	if len(hours) == 0 {
+		return fmt.Sprintf("%s已经过去了，换个时间问问吧。\n%s", q.TimeText, origin), nil
+	}
+	subject := q.TimeText
+	var answer string
+	switch q.Aspect {
+	case nlp.AspectRain:
+		answer = answerPrecipitation(subject, hours, displayNow, "雨", "RAIN")
+	case nlp.AspectSnow:
+		answer = answerPrecipitation(subject, hours, displayNow, "雪", "SNOW")
+	case nlp.AspectTemperature:
+		answer = answerTemperature(subject, hours)
+	case nlp.AspectWind:
+		answer = answerWind(subject, hours)
+	case nlp.AspectAir:
+		answer = answerAir(subject, hours)
+	default:
+		answer = answerGeneral(subject, hours)
+	}
+	return answer + "\n" + origin, nil
+}
+
+// hourlySteps 回答问题需要的小时级别预报条数，到问题最后一天或时段结束为止，最多 MaxForecastDays 天
+// 星期几和周末按服务器时间估算，当地日期可能相差一天，多取一天
+func hourlySteps(q nlp.Query) int {
+	steps := (q.Day + q.Days) * 24
+	if q.Days == 1 && q.ToHour > 0 && !q.Current {
+		steps = q.Day*24 + q.ToHour
+	}
+	if q.Weekday > 0 || q.Weekend {
+		steps += 24
+	}
+	if steps > MaxForecastDays*24 {
+		steps = MaxForecastDays * 24
+	}
+	return steps
+}
+
+// selectHours 按问题的日期和时段筛选小时级别预报，日期和时段均按预报地点当地时间计算
+func selectHours(response CaiyunAPIHourlyResponse, localNow time.Time, q nlp.Query) ([]hourForecast, error) {
+	hourly := response.Result.Hourly
+	var hours []hourForecast
+	for i, v := range hourly.Skycon {
+		t, err := ParseCaiyunTime(v.Datetime, localNow.Location())
+		if err != nil {
+			return nil, err
+		}
+		day := dayDiff(t, localNow)
+		if day < q.Day || day >= q.Day+q.Days {
+			continue
+		}
+		if q.FromHour >= 0 && (t.Hour() < q.FromHour || t.Hour() >= q.ToHour) {
+			continue
+		}
+		h := hourForecast{time: t, skycon: v.Value}
+		if i < len(hourly.Temperature) {
+			h.temperature = hourly.Temperature[i].Value
+		}
+		if i < len(hourly.ApparentTemperature) {
+			h.apparentTemperature = hourly.ApparentTemperature[i].Value
+		}
+		if i < len(hourly.Precipitation) {
+			h.precipitation = hourly.Precipitation[i].Value
+			h.probability = hourly.Precipitation[i].Probability
+		}
+		if i < len(hourly.Wind) {
+			h.windSpeed = hourly.Wind[i].Speed
+			h.windDirection = hourly.Wind[i].Direction
+		}
+		if i < len(hourly.AirQuality.Aqi) {
+			h.aqi = hourly.AirQuality.Aqi[i].Value.Chn
+		}
+		hours = append(hours, h)
+	}
+	return hours, nil
+}
+
+// answerPrecipitation 回答会不会下雨、下雪
+// name 为「雨」或「雪」，skyconKeyword 为天气现象代码中的关键字
+func answerPrecipitation(subject string, hours []hourForecast, displayNow time.Time, name, skyconKeyword string) string {
+	maxProbability := 0
+	var first *hourForecast
+	for i := range hours {
+		if hours[i].probability > maxProbability {
+			maxProbability = hours[i].probability
+		}
+		if first == nil && strings.Contains(hours[i].skycon, skyconKeyword) {
+			first = &hours[i]
+		}
+	}
+	if first == nil {
+		return fmt.Sprintf("%s应该不会下%s，降水概率最高 %d%%。", subject, name, maxProbability)
+	}
+	start := RelativeTime(first.time, displayNow)
+	result := fmt.Sprintf("%s可能会下%s（%s），预计 %s 开始，降水概率最高 %d%%。", subject, name, SkyconParse(first.skycon), start, maxProbability)
+	if name == "雨" {
+		result += "出门记得带伞。"
+	}
+	return result
+}
+
+// answerTemperature 回答冷不冷、多少度
+func answerTemperature(subject string, hours []hourForecast) string {
+	minTemp, maxTemp := hours[0].temperature, hours[0].temperature
+	minFeel, maxFeel := hours[0].apparentTemperature, hours[0].apparentTemperature
+	var sum float64
+	for _, h := range hours {
+		if h.temperature < minTemp {
+			minTemp = h.temperature
+		}
+		if h.temperature > maxTemp {
+			maxTemp = h.temperature
+		}
+		if h.apparentTemperature < minFeel {
+			minFeel = h.apparentTemperature
+		}
+		if h.apparentTemperature > maxFeel {
+			maxFeel = h.apparentTemperature
+		}
+		sum += h.apparentTemperature
+	}
+	feel := temperatureFeel(sum / float64(len(hours)))
+	if len(hours) == 1 {
+		return fmt.Sprintf("%s气温 %.1f ℃，体感 %.1f ℃，%s。", subject, minTemp, minFeel, feel)
+	}
+	return fmt.Sprintf("%s气温 %.1f ~ %.1f ℃，体感 %.1f ~ %.1f ℃，整体%s。", subject, minTemp, maxTemp, minFeel, maxFeel, feel)
+}
+
+// temperatureFeel 按体感温度描述冷热
+func temperatureFeel(apparentTemperature float64) string {
+	switch {
+	case apparentTemperature < 0:
+		return "很冷，注意保暖"
+	case apparentTemperature < 10:
+		return "比较冷，记得穿厚点"
+	case apparentTemperature < 18:
+		return "有点凉，适当加件外套"
+	case apparentTemperature < 26:
+		return "比较舒适"
+	case apparentTemperature < 30:
+		return "有点热"
+	default:
+		return "很热，注意防暑"
+	}
+}
+
+// answerWind 回答风大不大
+func answerWind(subject string, hours []hourForecast) string {
+	strongest := hours[0]
+	for _, h := range hours {
+		if h.windSpeed > strongest.windSpeed {
+			strongest = h
+		}
+	}
+	level := beaufortLevel(strongest.windSpeed)
+	var judgement string
+	switch {
+	case level >= 6:
+		judgement = "风比较大，注意安全"
+	case level >= 4:
+		judgement = "有点风"
+	default:
+		judgement = "风不大"
+	}
+	return fmt.Sprintf("%s%s，%s最大 %d 级（%.1f km/h）。", subject, judgement, windDirectionParse(strongest.windDirection), level, strongest.windSpeed)
+}
+
+// beaufortLevel 风速(km/h)对应的蒲福风级
+func beaufortLevel(speed float64) int {
+	level := 0
+	for i, v := range beaufortSpeeds {
+		if speed >= v {
+			level = i + 1
+		}
+	}
+	return level
+}
+
+// answerAir 回答空气质量
+func answerAir(subject string, hours []hourForecast) string {
+	maxAqi, sum := 0, 0
+	for _, h := range hours {
+		if h.aqi > maxAqi {
+			maxAqi = h.aqi
+		}
+		sum += h.aqi
+	}
+	avg := sum / len(hours)
+	if len(hours) == 1 {
+		return fmt.Sprintf("%s国标 AQI %d，空气质量%s。", subject, avg, aqiLevel(avg))
+	}
+	return fmt.Sprintf("%s国标 AQI 平均 %d，最高 %d，空气质量%s。", subject, avg, maxAqi, aqiLevel(maxAqi))
+}
+
+// aqiLevel 国标 AQI 对应的空气质量等级
+func aqiLevel(aqi int) string {
+	switch {
+	case aqi <= 50:
+		return "优"
+	case aqi <= 100:
+		return "良"
+	case aqi <= 150:
+		return "轻度污染"
+	case aqi <= 200:
+		return "中度污染"
+	case aqi <= 300:
+		return "重度污染"
+	default:
+		return "严重污染"
+	}
+}
+
+// answerGeneral 回答天气怎么样，概括天气现象、气温、降水和风
+func answerGeneral(subject string, hours []hourForecast) string {
+	counts := make(map[string]int)
+	mainSkycon := hours[0].skycon
+	for _, h := range hours {
+		counts[h.skycon]++
+		if counts[h.skycon] > counts[mainSkycon] {
+			mainSkycon = h.skycon
+		}
+	}
+	minTemp, maxTemp := hours[0].temperature, hours[0].temperature
+	maxProbability := 0
+	maxWind := 0.0
+	for _, h := range hours {
+		if h.temperature < minTemp {
+			minTemp = h.temperature
+		}
+		if h.temperature > maxTemp {
+			maxTemp = h.temperature
+		}
+		if h.probability > maxProbability {
+			maxProbability = h.probability
+		}
+		if h.windSpeed > maxWind {
+			maxWind = h.windSpeed
+		}
+	}
+	temperature := fmt.Sprintf("%.1f ℃", minTemp)
+	if len(hours) > 1 {
+		temperature = fmt.Sprintf("%.1f ~ %.1f ℃", minTemp, maxTemp)
+	}
+	return fmt.Sprintf("%s以%s为主，气温 %s，降水概率最高 %d%%，风力最大 %d 级。", subject, SkyconParse(mainSkycon), temperature, maxProbability, beaufortLevel(maxWind))
+}
@@ -2,7 +2,10 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/pkg"
 )
@@ -13,10 +16,52 @@ const CaiyunAPIUrl string = "https://api.caiyunapp.com"
 // CaiyunAPIVersion caiyun api version
 const CaiyunAPIVersion string = "v2.6"
 
+// Verbosity 输出的详细程度
+type Verbosity string
+
+const (
+	VerbosityBrief  Verbosity = "brief"  // 只有最关键的几项
+	VerbosityNormal Verbosity = "normal" // 去掉专业性较强的数据
+	VerbosityFull   Verbosity = "full"   // 全部数据
+)
+
+// ParseVerbosity 解析详细程度，无法识别时返回 false
+func ParseVerbosity(s string) (Verbosity, bool) {
+	switch Verbosity(s) {
+	case VerbosityBrief, VerbosityNormal, VerbosityFull:
+		return Verbosity(s), true
+	}
+	return VerbosityFull, false
+}
+
 // Caiyun 彩云天气
 // https://caiyunapp.com/
 type Caiyun struct {
-	APIKey string
+	APIKey          string
+	DisplayTimezone *time.Location // 显示时间使用的时区，nil 时使用预报地点的时区
+	Verbosity       Verbosity      // 输出的详细程度，为空时输出全部数据
+}
+
+// detail 一行输出及其需要的详细程度
+type detail struct {
+	verbosity Verbosity
+	text      string
+}
+
+// compose 按详细程度拼接输出
+func (c *Caiyun) compose(details []detail) string {
+	rank := map[Verbosity]int{VerbosityBrief: 0, VerbosityNormal: 1, VerbosityFull: 2}
+	level, ok := rank[c.Verbosity]
+	if !ok {
+		level = rank[VerbosityFull]
+	}
+	result := ""
+	for _, d := range details {
+		if rank[d.verbosity] <= level {
+			result += d.text
+		}
+	}
+	return result
 }
 
 // NewCaiyun Create Caiyun
@@ -26,11 +71,25 @@ func NewCaiyun(apiKey string) *Caiyun {
 	}
 }
 
+// String 用于日志输出，隐藏 api key
+func (c Caiyun) String() string {
+	return fmt.Sprintf("{APIKey:%s DisplayTimezone:%v Verbosity:%v}", pkg.Redact(c.APIKey), c.DisplayTimezone, c.Verbosity)
+}
+
+// get 请求彩云天气 api，api key 是请求地址的一部分，错误信息中的 api key 会被隐藏，避免写入日志
+func (c *Caiyun) get(url string, queryList [][]string) ([]byte, error) {
+	body, err := pkg.HTTPGetRequest(url, queryList)
+	if err != nil && c.APIKey != "" {
+		return nil, errors.New(strings.ReplaceAll(err.Error(), c.APIKey, pkg.RedactedText))
+	}
+	return body, err
+}
+
 // RealTime 实时天气情况
 func (c *Caiyun) RealTime(longitude, latitude float64) (string, error) {
 	url := fmt.Sprintf("%s/%s/%s/%f,%f/realtime", CaiyunAPIUrl, CaiyunAPIVersion, c.APIKey, longitude, latitude)
 	var realtimeResponse CaiyunAPIRealTimeResponse
-	responseBody, err := pkg.HTTPGetRequest(url, [][]string{
+	responseBody, err := c.get(url, [][]string{
 		{"unit", "metric:v2"},
 		{"lang", "zh_CN"},
 	})
@@ -65,11 +124,31 @@ func (c *Caiyun) RealTime(longitude, latitude float64) (string, error) {
 	co := fmt.Sprintf("一氧化碳浓度 %.2f μg/m3\n", realTime.AirQuality.CO)
 	aqi := fmt.Sprintf("国标 AQI 指数 %d\n", realTime.AirQuality.AQI.CHN)
 	aqiQuality := fmt.Sprintf("空气质量 %s\n", realTime.AirQuality.Description.CHN)
-	airQuality := aqi + aqiQuality + pm25 + pm10 + o3 + so2 + no2 + co
 	ultraviolet := fmt.Sprintf("紫外线强度 %s\n", realTime.LifeIndex.Ultraviolet.Description)
 	comfort := fmt.Sprintf("舒适度 %s\n", realTime.LifeIndex.Comfortable.Description)
-	origin := "信息来源：彩云天气"
-	result := temperature + humidity + skycon + visibility + dswrf + windSpeed + windDirection + pressure + apparentTemperature + intensity + airQuality + ultraviolet + comfort + origin
+	displayZone := c.displayZone(LocationZone(realtimeResponse.Timezone, realtimeResponse.TZShift))
+	now := time.Unix(realtimeResponse.ServerTime, 0).In(displayZone)
+	updated := fmt.Sprintf("更新时间 %s\n", RelativeTime(now, now))
+	origin := c.zoneNote(displayZone) + "信息来源：彩云天气"
+	result := c.compose([]detail{
+		{VerbosityBrief, temperature},
+		{VerbosityNormal, humidity},
+		{VerbosityBrief, skycon},
+		{VerbosityFull, visibility},
+		{VerbosityFull, dswrf},
+		{VerbosityNormal, windSpeed},
+		{VerbosityNormal, windDirection},
+		{VerbosityFull, pressure},
+		{VerbosityBrief, apparentTemperature},
+		{VerbosityNormal, intensity},
+		{VerbosityNormal, aqi},
+		{VerbosityBrief, aqiQuality},
+		{VerbosityFull, pm25 + pm10 + o3 + so2 + no2 + co},
+		{VerbosityNormal, ultraviolet},
+		{VerbosityNormal, comfort},
+		{VerbosityBrief, updated},
+		{VerbosityBrief, origin},
+	})
 	return result, nil
 }
 
@@ -77,7 +156,7 @@ func (c *Caiyun) RealTime(longitude, latitude float64) (string, error) {
 func (c *Caiyun) Rain(longitude, latitude float64) (string, error) {
 	url := fmt.Sprintf("%s/%s/%s/%f,%f/minutely", CaiyunAPIUrl, CaiyunAPIVersion, c.APIKey, longitude, latitude)
 	var minutelyResponse CaiyunAPIMinutelyResponse
-	responseBody, err := pkg.HTTPGetRequest(url, [][]string{
+	responseBody, err := c.get(url, [][]string{
 		{"unit", "metric:v2"},
 		{"lang", "zh_CN"},
 	})
@@ -88,11 +167,11 @@ func (c *Caiyun) Rain(longitude, latitude float64) (string, error) {
 		return "json 解析错误", err
 	}
 	if minutelyResponse.Status != "ok" {
-		return "api 错误", err
+		return "api 错误", fmt.Errorf("caiyun api error")
 	}
 	minutely := minutelyResponse.Result.Minutely
 	if minutely.Status != "ok" {
-		return "minutely api 错误", err
+		return "minutely api 错误", fmt.Errorf("caiyun minutely error")
 	}
 	probability := "未来两小时每半小时的降水概率："
 	for _, v := range minutely.Probability {
@@ -100,8 +179,16 @@ func (c *Caiyun) Rain(longitude, latitude float64) (string, error) {
 	}
 	probability += "\n\n"
 	description := minutely.Description + "\n"
-	source := "数据来源：彩云天气"
-	result := probability + description + source
+	displayZone := c.displayZone(LocationZone(minutelyResponse.Timezone, minutelyResponse.Tzshift))
+	now := time.Unix(int64(minutelyResponse.ServerTime), 0).In(displayZone)
+	updated := fmt.Sprintf("更新时间 %s\n", RelativeTime(now, now))
+	source := c.zoneNote(displayZone) + "数据来源：彩云天气"
+	result := c.compose([]detail{
+		{VerbosityNormal, probability},
+		{VerbosityBrief, description},
+		{VerbosityBrief, updated},
+		{VerbosityBrief, source},
+	})
 
 	return result, nil
 }
@@ -119,11 +206,13 @@ func (c *Caiyun) Tomorrow(longitude, latitude float64) (string, error) {
 // getDayWeather 获取某一天的天气
 // 0 <= dayIndex < 15
 // 0 代表今天，1 代表明天，以此类推
+// 「今天」按预报地点所在时区计算，而不是服务器时区
 func (c *Caiyun) getDayWeather(longitude, latitude float64, dayIndex int) (string, error) {
 	url := fmt.Sprintf("%s/%s/%s/%f,%f/daily", CaiyunAPIUrl, CaiyunAPIVersion, c.APIKey, longitude, latitude)
 	var dailyResponse CaiyunAPIDailyResponse
-	responseBody, err := pkg.HTTPGetRequest(url, [][]string{
-		{"dailysteps", fmt.Sprint(dayIndex + 1)},
+	responseBody, err := c.get(url, [][]string{
+		// 多取一天，避免预报起始日期与当地日期不一致时越界
+		{"dailysteps", fmt.Sprint(dayIndex + 2)},
 		{"unit", "metric:v2"},
 		{"lang", "zh_CN"},
 	})
@@ -134,35 +223,113 @@ func (c *Caiyun) getDayWeather(longitude, latitude float64, dayIndex int) (strin
 		return "json 解析错误", err
 	}
 	if dailyResponse.Status != "ok" {
-		return "api 错误", err
+		return "api 错误", fmt.Errorf("caiyun api error")
 	}
 	daily := dailyResponse.Result.Daily
 	if daily.Status != "ok" {
+		return "daily api 错误", fmt.Errorf("caiyun daily error")
+	}
+	locationZone := LocationZone(dailyResponse.Timezone, dailyResponse.TZShift)
+	displayZone := c.displayZone(locationZone)
+	localNow := time.Unix(dailyResponse.ServerTime, 0).In(locationZone)
+	displayNow := localNow.In(displayZone)
+	dates := make([]string, len(daily.Temperature))
+	for i, v := range daily.Temperature {
+		dates[i] = v.Date
+	}
+	index, date, err := localDayIndex(dates, localNow, dayIndex)
+	if err != nil {
 		return "daily api 错误", err
 	}
-	temperature := fmt.Sprintf("全天气温(℃) %.1f ~ %.1f 平均 %.1f\n", daily.Temperature[dayIndex].Min, daily.Temperature[dayIndex].Max, daily.Temperature[dayIndex].Avg)
-	humidity := fmt.Sprintf("全天相对湿度 %0.1f%% ~ %0.1f%% 平均 %0.1f%%\n", daily.Humidity[dayIndex].Min, daily.Humidity[dayIndex].Max, daily.Humidity[dayIndex].Avg)
-	skycon := fmt.Sprintf("全天主要天气现象 %s\n", SkyconParse(daily.Skycon[dayIndex].Value))
-	intensity := fmt.Sprintf("全天降水强度(mm/hr) %.2f ~ %.2f 平均 %.2f\n", daily.Precipitation[dayIndex].Min, daily.Precipitation[dayIndex].Max, daily.Precipitation[dayIndex].Avg)
-	probability := fmt.Sprintf("全天降水概率 %.0f%%\n", daily.Precipitation[dayIndex].Probability*100)
-	wind := fmt.Sprintf("全天风速(km/hr) %.2f ~ %.2f 平均 %.2f\n", daily.Wind[dayIndex].Min.Speed, daily.Wind[dayIndex].Max.Speed, daily.Wind[dayIndex].Avg.Speed)
-	pressure := fmt.Sprintf("全天地面气压(Pa) %.2f ~ %.2f 平均 %.2f\n", daily.Pressure[dayIndex].Min, daily.Pressure[dayIndex].Max, daily.Pressure[dayIndex].Avg)
-	visibility := fmt.Sprintf("全天地表水平能见度 %.1f ~ %.1f 平均 %.1f\n", daily.Visibility[dayIndex].Min, daily.Visibility[dayIndex].Max, daily.Visibility[dayIndex].Avg)
-	dswrf := fmt.Sprintf("全天向下短波辐射通量(W/M2) %.1f ~ %.1f 平均 %.1f\n", daily.Dswrf[dayIndex].Min, daily.Dswrf[dayIndex].Max, daily.Dswrf[dayIndex].Avg)
-	aqi := fmt.Sprintf("全天国标 AQI %d ~ %d 平均 %d\n", daily.AirQuality.Aqi[dayIndex].Min.Chn, daily.AirQuality.Aqi[dayIndex].Max.Chn, daily.AirQuality.Aqi[dayIndex].Avg.Chn)
-	pm25 := fmt.Sprintf("全天 PM2.5 浓度 %d ~ %d 平均 %d\n", daily.AirQuality.Pm25[dayIndex].Min, daily.AirQuality.Pm25[dayIndex].Max, daily.AirQuality.Pm25[dayIndex].Avg)
-	sunrise := fmt.Sprintf("日出 %s\n", daily.Astro[dayIndex].Sunrise.Time)
-	sunset := fmt.Sprintf("日落 %s\n", daily.Astro[dayIndex].Sunset.Time)
-	ultraviolet := fmt.Sprintf("紫外线强度 %s\n", daily.LifeIndex.Ultraviolet[dayIndex].Description)
-	carwashing := fmt.Sprintf("洗车指数 %s\n", daily.LifeIndex.CarWashing[dayIndex].Description)
-	dressing := fmt.Sprintf("穿衣指数 %s\n", daily.LifeIndex.Dressing[dayIndex].Description)
-	comfort := fmt.Sprintf("舒适指数 %s\n", daily.LifeIndex.Comfort[dayIndex].Description)
-	coldrisk := fmt.Sprintf("感冒指数 %s\n", daily.LifeIndex.ColdRisk[dayIndex].Description)
-	origin := "信息来源：彩云天气"
-	result := temperature + humidity + skycon + intensity + probability + wind + pressure + visibility + dswrf + aqi + pm25 + sunrise + sunset + ultraviolet + carwashing + dressing + comfort + coldrisk + origin
+	dateLabel := fmt.Sprintf("日期 %s\n", DateLabel(date, localNow))
+	temperature := fmt.Sprintf("全天气温(℃) %.1f ~ %.1f 平均 %.1f\n", daily.Temperature[index].Min, daily.Temperature[index].Max, daily.Temperature[index].Avg)
+	humidity := fmt.Sprintf("全天相对湿度 %0.1f%% ~ %0.1f%% 平均 %0.1f%%\n", daily.Humidity[index].Min, daily.Humidity[index].Max, daily.Humidity[index].Avg)
+	skycon := fmt.Sprintf("全天主要天气现象 %s\n", SkyconParse(daily.Skycon[index].Value))
+	dayNight := fmt.Sprintf("%s白天 %s，%s %s\n", RelativeDay(date, localNow), SkyconParse(daily.Skycon08H20H[index].Value), RelativePeriod(date.Add(20*time.Hour), localNow), SkyconParse(daily.Skycon20H32H[index].Value))
+	intensity := fmt.Sprintf("全天降水强度(mm/hr) %.2f ~ %.2f 平均 %.2f\n", daily.Precipitation[index].Min, daily.Precipitation[index].Max, daily.Precipitation[index].Avg)
+	probability := fmt.Sprintf("全天降水概率 %.0f%%\n", daily.Precipitation[index].Probability*100)
+	wind := fmt.Sprintf("全天风速(km/hr) %.2f ~ %.2f 平均 %.2f\n", daily.Wind[index].Min.Speed, daily.Wind[index].Max.Speed, daily.Wind[index].Avg.Speed)
+	pressure := fmt.Sprintf("全天地面气压(Pa) %.2f ~ %.2f 平均 %.2f\n", daily.Pressure[index].Min, daily.Pressure[index].Max, daily.Pressure[index].Avg)
+	visibility := fmt.Sprintf("全天地表水平能见度 %.1f ~ %.1f 平均 %.1f\n", daily.Visibility[index].Min, daily.Visibility[index].Max, daily.Visibility[index].Avg)
+	dswrf := fmt.Sprintf("全天向下短波辐射通量(W/M2) %.1f ~ %.1f 平均 %.1f\n", daily.Dswrf[index].Min, daily.Dswrf[index].Max, daily.Dswrf[index].Avg)
+	aqi := fmt.Sprintf("全天国标 AQI %d ~ %d 平均 %d\n", daily.AirQuality.Aqi[index].Min.Chn, daily.AirQuality.Aqi[index].Max.Chn, daily.AirQuality.Aqi[index].Avg.Chn)
+	pm25 := fmt.Sprintf("全天 PM2.5 浓度 %d ~ %d 平均 %d\n", daily.AirQuality.Pm25[index].Min, daily.AirQuality.Pm25[index].Max, daily.AirQuality.Pm25[index].Avg)
+	sunrise := fmt.Sprintf("日出 %s\n", astroTime(date, daily.Astro[index].Sunrise.Time, displayNow))
+	sunset := fmt.Sprintf("日落 %s\n", astroTime(date, daily.Astro[index].Sunset.Time, displayNow))
+	ultraviolet := fmt.Sprintf("紫外线强度 %s\n", daily.LifeIndex.Ultraviolet[index].Description)
+	carwashing := fmt.Sprintf("洗车指数 %s\n", daily.LifeIndex.CarWashing[index].Description)
+	dressing := fmt.Sprintf("穿衣指数 %s\n", daily.LifeIndex.Dressing[index].Description)
+	comfort := fmt.Sprintf("舒适指数 %s\n", daily.LifeIndex.Comfort[index].Description)
+	coldrisk := fmt.Sprintf("感冒指数 %s\n", daily.LifeIndex.ColdRisk[index].Description)
+	origin := c.zoneNote(displayZone) + "信息来源：彩云天气"
+	result := c.compose([]detail{
+		{VerbosityBrief, dateLabel},
+		{VerbosityBrief, temperature},
+		{VerbosityNormal, humidity},
+		{VerbosityBrief, skycon},
+		{VerbosityBrief, dayNight},
+		{VerbosityFull, intensity},
+		{VerbosityBrief, probability},
+		{VerbosityNormal, wind},
+		{VerbosityFull, pressure},
+		{VerbosityFull, visibility},
+		{VerbosityFull, dswrf},
+		{VerbosityNormal, aqi},
+		{VerbosityFull, pm25},
+		{VerbosityNormal, sunrise},
+		{VerbosityNormal, sunset},
+		{VerbosityNormal, ultraviolet},
+		{VerbosityFull, carwashing},
+		{VerbosityNormal, dressing},
+		{VerbosityFull, comfort},
+		{VerbosityNormal, coldrisk},
+		{VerbosityBrief, origin},
+	})
 	return result, nil
 }
 
+// localDayIndex 在天级别预报中找到预报地点当地的第 dayOffset 天
+// now 需为预报地点时区的当前时间
+func localDayIndex(dates []string, now time.Time, dayOffset int) (int, time.Time, error) {
+	target := now.AddDate(0, 0, dayOffset)
+	for i, v := range dates {
+		date, err := ParseCaiyunTime(v, now.Location())
+		if err != nil {
+			return 0, date, err
+		}
+		if dayDiff(date, target) == 0 {
+			return i, date, nil
+		}
+	}
+	return 0, target, fmt.Errorf("day %s not found in daily forecast", target.Format("2006-01-02"))
+}
+
+// astroTime 日出日落时间
+// 彩云返回的是当地时刻（如 05:48），date 为预报地点时区的日期
+func astroTime(date time.Time, clock string, now time.Time) string {
+	t, err := time.ParseInLocation("2006-01-02 15:04", date.Format("2006-01-02")+" "+clock, date.Location())
+	if err != nil {
+		return clock
+	}
+	return RelativeTime(t, now)
+}
+
+// displayZone 显示时间使用的时区
+func (c *Caiyun) displayZone(locationZone *time.Location) *time.Location {
+	if c.DisplayTimezone != nil {
+		return c.DisplayTimezone
+	}
+	return locationZone
+}
+
+// zoneNote 使用自定义时区显示时的提示
+func (c *Caiyun) zoneNote(displayZone *time.Location) string {
+	if c.DisplayTimezone == nil {
+		return ""
+	}
+	return fmt.Sprintf("时间按 %s 时区显示\n", displayZone)
+}
+
 // CaiyunAPIRealTimeResponse 实时天气情况返回
 // https://docs.caiyunapp.com/docs/realtime
 type CaiyunAPIRealTimeResponse struct {
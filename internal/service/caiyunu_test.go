@@ -0,0 +1,56 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/nlp"
+)
+
+// roundTripFunc 用函数代替 http.RoundTripper，测试时不访问网络
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// stubCaiyun 让彩云天气 api 的请求都返回 body
+func stubCaiyun(t *testing.T, body string) {
+	transport := http.DefaultTransport
+	http.DefaultTransport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	})
+	t.Cleanup(func() { http.DefaultTransport = transport })
+}
+
+// TestCaiyunFailedStatus 彩云天气返回的 status 不是 ok 时需要返回错误，调用方据此退还调用次数
+func TestCaiyunFailedStatus(t *testing.T) {
+	queries := map[string]func(c *Caiyun) (string, error){
+		"RealTime": func(c *Caiyun) (string, error) { return c.RealTime(116.4074, 39.9042) },
+		"Rain":     func(c *Caiyun) (string, error) { return c.Rain(116.4074, 39.9042) },
+		"Today":    func(c *Caiyun) (string, error) { return c.Today(116.4074, 39.9042) },
+		"Tomorrow": func(c *Caiyun) (string, error) { return c.Tomorrow(116.4074, 39.9042) },
+		"Answer": func(c *Caiyun) (string, error) {
+			return c.Answer(116.4074, 39.9042, nlp.Query{Days: 1, FromHour: -1, ToHour: -1})
+		},
+	}
+	responses := map[string]string{
+		"api":    `{"status":"failed","error":"token is invalid"}`,
+		"result": `{"status":"ok","result":{"realtime":{"status":"failed"},"minutely":{"status":"failed"},"daily":{"status":"failed"},"hourly":{"status":"failed"}}}`,
+	}
+	for name, body := range responses {
+		stubCaiyun(t, body)
+		for query, call := range queries {
+			if reply, err := call(NewCaiyun("test")); err == nil {
+				t.Errorf("%s with %s failure: got reply %q without error", query, name, reply)
+			}
+		}
+	}
+}
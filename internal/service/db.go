@@ -3,6 +3,7 @@ package service
 import (
 	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/database/model"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // DBService 数据库服务
@@ -35,6 +36,31 @@ func (d *DBService) GetUser(uin int64) (model.User, error) {
 	return user, err
 }
 
+// GetUsers 按 QQ 号批量获取用户，未保存地址的用户不在结果中
+func (d *DBService) GetUsers(uins []int64) ([]model.User, error) {
+	var users []model.User
+	err := d.db.Where("uin IN ?", uins).Find(&users).Error
+	return users, err
+}
+
+// GetTopUsers 获取 date 当天调用次数最多的用户，不包括调用次数为 0 的用户
+func (d *DBService) GetTopUsers(date string, limit int) ([]model.User, error) {
+	var users []model.User
+	err := d.db.Where("reset_date = ? AND times > 0", date).Order("times DESC").Order("uin").Limit(limit).Find(&users).Error
+	return users, err
+}
+
+// GetUsageSummary 获取全部用户数、date 当天有调用的用户数和总调用次数
+func (d *DBService) GetUsageSummary(date string) (int64, int64, int64, error) {
+	var result struct {
+		Users       int64
+		ActiveUsers int64
+		Times       int64
+	}
+	err := d.db.Model(&model.User{}).Select("COUNT(*) AS users, COALESCE(SUM(CASE WHEN reset_date = ? AND times > 0 THEN 1 ELSE 0 END), 0) AS active_users, COALESCE(SUM(CASE WHEN reset_date = ? THEN times ELSE 0 END), 0) AS times", date, date).Scan(&result).Error
+	return result.Users, result.ActiveUsers, result.Times, err
+}
+
 // GetUserLocation 获取用户位置
 func (d *DBService) GetUserLocation(uin int64) (float64, float64, error) {
 	var user model.User
@@ -42,11 +68,11 @@ func (d *DBService) GetUserLocation(uin int64) (float64, float64, error) {
 	return user.Longitude, user.Latitude, err
 }
 
-// GetUserTimes 获得用户的调用次数
-func (d *DBService) GetUserTimes(uin int64) (int, error) {
+// GetUserTimes 获得用户 date 当天的调用次数
+func (d *DBService) GetUserTimes(uin int64, date string) (int, error) {
 	var user model.User
-	err := d.db.Select("times").Where("uin = ?", uin).First(&user).Error
-	return user.Times, err
+	err := d.db.Select("times", "reset_date").Where("uin = ?", uin).First(&user).Error
+	return user.TimesOn(date), err
 }
 
 // UpdateUserInfo 更新用户信息
@@ -54,14 +80,38 @@ func (d *DBService) UpdateUserInfo(uin int64, name string, longitude float64, la
 	return d.db.Model(&model.User{}).Where("uin = ?", uin).Update("name", name).Update("longitude", longitude).Update("latitude", latitude).Error
 }
 
+// UpdateUserTimezone 更新用户显示时区
+func (d *DBService) UpdateUserTimezone(uin int64, timezone string) error {
+	return d.db.Model(&model.User{}).Where("uin = ?", uin).Update("timezone", timezone).Error
+}
+
+// UpdateUserTier 更新用户等级
+func (d *DBService) UpdateUserTier(uin int64, tier string) error {
+	return d.db.Model(&model.User{}).Where("uin = ?", uin).Update("tier", tier).Error
+}
+
 // UpdateUserTimes 更新用户调用次数信息
 func (d *DBService) UpdateUserTimes(uin int64, times int) error {
 	return d.db.Model(&model.User{}).Where("uin = ?", uin).Update("times", times).Error
 }
 
-// IncreaseUserTimes 增加用户调用次数
-func (d *DBService) IncreaseUserTimes(uin int64) error {
-	return d.db.Model(&model.User{}).Where("uin = ?", uin).Update("times", gorm.Expr("times + 1")).Error
+// ReserveUserTimes 预扣用户 date 当天的 cost 次调用次数，调用次数属于之前的日期时先清零
+// limit 小于 0 时不限，预扣后超出 limit 时不扣除并返回 false
+func (d *DBService) ReserveUserTimes(uin int64, date string, cost, limit int) (bool, error) {
+	if err := d.db.Model(&model.User{}).Where("uin = ? AND (reset_date IS NULL OR reset_date <> ?)", uin, date).Updates(map[string]interface{}{"times": 0, "reset_date": date}).Error; err != nil {
+		return false, err
+	}
+	tx := d.db.Model(&model.User{}).Where("uin = ?", uin)
+	if limit >= 0 {
+		tx = tx.Where("times + ? <= ?", cost, limit)
+	}
+	result := tx.Update("times", gorm.Expr("times + ?", cost))
+	return result.RowsAffected > 0, result.Error
+}
+
+// RefundUserTimes 退还预扣的调用次数，日期已经变化时不退还
+func (d *DBService) RefundUserTimes(uin int64, date string, cost int) error {
+	return d.db.Model(&model.User{}).Where("uin = ? AND reset_date = ? AND times >= ?", uin, date, cost).Update("times", gorm.Expr("times - ?", cost)).Error
 }
 
 // ClearUserTimes 清空用户调用次数
@@ -75,3 +125,157 @@ func (d *DBService) ClearUserTimes(uin int64) error {
 func (d *DBService) ClearAllUserTimes() error {
 	return d.db.Model(&model.User{}).Where("1 = 1").Update("times", 0).Error
 }
+
+// GetGroupSetting 获取群设置，没有记录时返回默认设置
+func (d *DBService) GetGroupSetting(groupCode int64) (model.GroupSetting, error) {
+	var setting model.GroupSetting
+	err := d.db.Where("group_code = ?", groupCode).First(&setting).Error
+	if err == gorm.ErrRecordNotFound {
+		return model.DefaultGroupSetting(groupCode), nil
+	}
+	return setting, err
+}
+
+// UpdateGroupSetting 更新群设置的一项，没有记录时先按默认设置创建
+// column 为数据库列名，如 realtime、daily_limit
+func (d *DBService) UpdateGroupSetting(groupCode int64, column string, value interface{}) error {
+	if err := d.ensureGroupSetting(groupCode); err != nil {
+		return err
+	}
+	return d.db.Model(&model.GroupSetting{}).Where("group_code = ?", groupCode).Update(column, value).Error
+}
+
+// ensureGroupSetting 没有群设置记录时按默认设置创建
+// 新群的第一批查询可能同时到达，记录已存在时什么都不做，不会因为唯一索引冲突而报错
+func (d *DBService) ensureGroupSetting(groupCode int64) error {
+	setting := model.DefaultGroupSetting(groupCode)
+	return d.db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "group_code"}}, DoNothing: true}).Create(&setting).Error
+}
+
+// GetGroupTimes 获得群 date 当天的调用次数
+func (d *DBService) GetGroupTimes(groupCode int64, date string) (int, error) {
+	var setting model.GroupSetting
+	err := d.db.Select("times", "reset_date").Where("group_code = ?", groupCode).First(&setting).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, nil
+	}
+	return setting.TimesOn(date), err
+}
+
+// ReserveGroupTimes 预扣群 date 当天的 cost 次调用次数，调用次数属于之前的日期时先清零
+// budget 小于 0 时不限，预扣后超出 budget 时不扣除并返回 false
+func (d *DBService) ReserveGroupTimes(groupCode int64, date string, cost, budget int) (bool, error) {
+	if err := d.ensureGroupSetting(groupCode); err != nil {
+		return false, err
+	}
+	if err := d.db.Model(&model.GroupSetting{}).Where("group_code = ? AND (reset_date IS NULL OR reset_date <> ?)", groupCode, date).Updates(map[string]interface{}{"times": 0, "reset_date": date}).Error; err != nil {
+		return false, err
+	}
+	tx := d.db.Model(&model.GroupSetting{}).Where("group_code = ?", groupCode)
+	if budget >= 0 {
+		tx = tx.Where("times + ? <= ?", cost, budget)
+	}
+	result := tx.Update("times", gorm.Expr("times + ?", cost))
+	return result.RowsAffected > 0, result.Error
+}
+
+// RefundGroupTimes 退还群预扣的调用次数，日期已经变化时不退还
+func (d *DBService) RefundGroupTimes(groupCode int64, date string, cost int) error {
+	return d.db.Model(&model.GroupSetting{}).Where("group_code = ? AND reset_date = ? AND times >= ?", groupCode, date, cost).Update("times", gorm.Expr("times - ?", cost)).Error
+}
+
+// ClearAllGroupTimes 清空全部群调用次数
+func (d *DBService) ClearAllGroupTimes() error {
+	return d.db.Model(&model.GroupSetting{}).Where("1 = 1").Update("times", 0).Error
+}
+
+// GetAccessLists 获取全部名单，按添加顺序排列
+func (d *DBService) GetAccessLists() (map[string][]int64, error) {
+	var entries []model.AccessEntry
+	if err := d.db.Order("id").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	lists := make(map[string][]int64)
+	for _, entry := range entries {
+		lists[entry.ListName] = append(lists[entry.ListName], entry.Target)
+	}
+	return lists, nil
+}
+
+// UpdateAccessList 批量添加或移除名单中的号码，每个有变化的号码记录一条修改记录
+// 返回有变化的号码，已在名单中的添加和不在名单中的移除会被跳过
+func (d *DBService) UpdateAccessList(list string, targets []int64, add bool, actor int64, reason string) ([]int64, error) {
+	var changed []int64
+	action := "remove"
+	if add {
+		action = "add"
+	}
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		for _, target := range targets {
+			var count int64
+			if err := tx.Model(&model.AccessEntry{}).Where("list_name = ? AND target = ?", list, target).Count(&count).Error; err != nil {
+				return err
+			}
+			if add == (count > 0) {
+				continue
+			}
+			var err error
+			if add {
+				err = tx.Create(&model.AccessEntry{ListName: list, Target: target}).Error
+			} else {
+				// 名单有唯一索引，直接删除记录以便之后重新添加
+				err = tx.Unscoped().Where("list_name = ? AND target = ?", list, target).Delete(&model.AccessEntry{}).Error
+			}
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(&model.AuditLog{Actor: actor, Action: action, ListName: list, Target: target, Reason: reason}).Error; err != nil {
+				return err
+			}
+			changed = append(changed, target)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// ImportAccessLists 从配置文件导入名单，每个号码记录一条 import 修改记录
+func (d *DBService) ImportAccessLists(lists map[string][]int64, reason string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		for list, targets := range lists {
+			for _, target := range targets {
+				var count int64
+				if err := tx.Model(&model.AccessEntry{}).Where("list_name = ? AND target = ?", list, target).Count(&count).Error; err != nil {
+					return err
+				}
+				if count > 0 {
+					continue
+				}
+				if err := tx.Create(&model.AccessEntry{ListName: list, Target: target}).Error; err != nil {
+					return err
+				}
+				if err := tx.Create(&model.AuditLog{Action: "import", ListName: list, Target: target, Reason: reason}).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// CountAuditLogs 获取名单修改记录的数量
+func (d *DBService) CountAuditLogs() (int64, error) {
+	var count int64
+	err := d.db.Model(&model.AuditLog{}).Count(&count).Error
+	return count, err
+}
+
+// GetAuditLogs 获取最近的名单修改记录，按时间倒序排列
+func (d *DBService) GetAuditLogs(limit int) ([]model.AuditLog, error) {
+	var logs []model.AuditLog
+	err := d.db.Order("id DESC").Limit(limit).Find(&logs).Error
+	return logs, err
+}
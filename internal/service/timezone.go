@@ -0,0 +1,112 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// CaiyunTimeLayout 彩云天气返回的时间格式，如 2022-09-01T14:00+08:00
+const CaiyunTimeLayout string = "2006-01-02T15:04Z07:00"
+
+// weekdayNames 星期名称
+var weekdayNames = [...]string{"周日", "周一", "周二", "周三", "周四", "周五", "周六"}
+
+// LocationZone 预报地点的时区
+// 优先使用 timezone（如 Asia/Shanghai），无法加载时使用 tzshift（秒）构造固定偏移时区
+func LocationZone(timezone string, tzshift int) *time.Location {
+	if timezone != "" {
+		if loc, err := time.LoadLocation(timezone); err == nil {
+			return loc
+		}
+	}
+	name := timezone
+	if name == "" {
+		name = fmt.Sprintf("UTC%+d", tzshift/3600)
+	}
+	return time.FixedZone(name, tzshift)
+}
+
+// ParseCaiyunTime 解析彩云天气返回的时间并转换到 loc 时区
+func ParseCaiyunTime(value string, loc *time.Location) (time.Time, error) {
+	t, err := time.Parse(CaiyunTimeLayout, value)
+	if err != nil {
+		return t, err
+	}
+	return t.In(loc), nil
+}
+
+// dayDiff t 与 now 相差的自然日数，以 now 所在时区为准
+func dayDiff(t, now time.Time) int {
+	t = t.In(now.Location())
+	ty, tm, td := t.Date()
+	ny, nm, nd := now.Date()
+	tDate := time.Date(ty, tm, td, 0, 0, 0, 0, time.UTC)
+	nDate := time.Date(ny, nm, nd, 0, 0, 0, 0, time.UTC)
+	return int(tDate.Sub(nDate).Hours() / 24)
+}
+
+// RelativeDay 相对日期，如「今天」「明天」「后天」，更远的日期显示为「9月3日 周六」
+func RelativeDay(t, now time.Time) string {
+	switch dayDiff(t, now) {
+	case -1:
+		return "昨天"
+	case 0:
+		return "今天"
+	case 1:
+		return "明天"
+	case 2:
+		return "后天"
+	}
+	t = t.In(now.Location())
+	return fmt.Sprintf("%d月%d日 %s", t.Month(), t.Day(), weekdayNames[t.Weekday()])
+}
+
+// RelativeTime 相对时间，如「今天 14:00」「明天 08:00」
+func RelativeTime(t, now time.Time) string {
+	return RelativeDay(t, now) + " " + t.In(now.Location()).Format("15:04")
+}
+
+// RelativePeriod 相对时段，如「今早」「今天下午」「明晚」「后天凌晨」
+func RelativePeriod(t, now time.Time) string {
+	t = t.In(now.Location())
+	var period string
+	switch hour := t.Hour(); {
+	case hour < 6:
+		period = "凌晨"
+	case hour < 9:
+		period = "早上"
+	case hour < 12:
+		period = "上午"
+	case hour < 14:
+		period = "中午"
+	case hour < 18:
+		period = "下午"
+	default:
+		period = "晚上"
+	}
+	day := RelativeDay(t, now)
+	short := map[string]string{
+		"昨天晚上": "昨晚",
+		"今天早上": "今早",
+		"今天晚上": "今晚",
+		"明天早上": "明早",
+		"明天晚上": "明晚",
+	}
+	if s, ok := short[day+period]; ok {
+		return s
+	}
+	if dayDiff(t, now) > 2 || dayDiff(t, now) < -1 {
+		return day + " " + period
+	}
+	return day + period
+}
+
+// DateLabel 日期标签，如「9月1日 周四（今天）」
+func DateLabel(t, now time.Time) string {
+	t = t.In(now.Location())
+	label := fmt.Sprintf("%d月%d日 %s", t.Month(), t.Day(), weekdayNames[t.Weekday()])
+	if diff := dayDiff(t, now); diff >= -1 && diff <= 2 {
+		return label + "（" + RelativeDay(t, now) + "）"
+	}
+	return label
+}
@@ -0,0 +1,63 @@
+package weather
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/nlp"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/service"
+)
+
+// answerQuestion 回答自然语言天气问题，如「明天会下雨吗」「周末北京天气怎么样」
+// 不像天气问题或者当前场景未开启时返回空字符串
+func answerQuestion(ctx *commandContext, msg string) string {
+	if !naturalLanguageEnabled(ctx) {
+		return ""
+	}
+	q, ok := nlp.Parse(msg, time.Now(), currentGeocoder())
+	if !ok {
+		return ""
+	}
+	if q.Day+q.Days > service.MaxForecastDays {
+		return fmt.Sprintf("只能查询未来 %d 天内的天气。", service.MaxForecastDays)
+	}
+	ctx.cost = questionCost(q)
+	ctx.topic = questionTopic(q)
+	title := q.TimeText + "天气"
+	if q.Location == "" {
+		return callWeatherAPI(ctx, title, func(c *service.Caiyun, longitude, latitude float64) (string, error) {
+			return c.Answer(longitude, latitude, q)
+		})
+	}
+	region, ok := currentGeocoder().Forward(q.Location)
+	if !ok {
+		return ""
+	}
+	return callWeatherAPIAt(ctx, title, &region, func(c *service.Caiyun, longitude, latitude float64) (string, error) {
+		return c.Answer(longitude, latitude, q)
+	})
+}
+
+// naturalLanguageEnabled 当前场景是否开启了自然语言问答
+// 群聊需要在许可名单中，并且群设置开启了 nlp，没有设置过时按 natural_language.groups；私聊需要开启 natural_language.private，频道暂不支持
+func naturalLanguageEnabled(ctx *commandContext) bool {
+	switch ctx.scope {
+	case scopePrivate:
+		return currentConfig().NaturalLanguage.Private
+	case scopeGroup:
+		return isAllowedGroup(ctx.groupCode) && groupFeatureEnabled(ctx.groupCode, featureNaturalLanguage)
+	}
+	return false
+}
+
+// questionCost 自然语言问题消耗的调用次数，需要的预报越长消耗越多
+func questionCost(q nlp.Query) int {
+	switch days := q.Day + q.Days; {
+	case days <= 2:
+		return 1
+	case days <= 7:
+		return 2
+	default:
+		return 3
+	}
+}
@@ -0,0 +1,217 @@
+package weather
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/database"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/service"
+)
+
+// DefaultResetTimezone 每日调用次数清零使用的默认时区
+const DefaultResetTimezone string = "Asia/Shanghai"
+
+// dateLayout 调用次数所属日期的格式
+const dateLayout string = "2006-01-02"
+
+// resetLocation 每日调用次数清零使用的时区
+func resetLocation() *time.Location {
+	return currentConfig().resetLoc
+}
+
+// loadResetLocation 加载清零时区，为空时使用默认时区，配置错误时也使用默认时区
+func loadResetLocation(name string) *time.Location {
+	if name == "" {
+		name = DefaultResetTimezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to load reset timezone %s, use %s instead.", name, DefaultResetTimezone)
+		if loc, err = time.LoadLocation(DefaultResetTimezone); err != nil {
+			loc = time.FixedZone("CST", 8*60*60)
+		}
+	}
+	return loc
+}
+
+// today 按清零时区计算的当天日期，调用次数不属于当天时视为 0
+func today() string {
+	return time.Now().In(resetLocation()).Format(dateLayout)
+}
+
+// DefaultCost 指令未声明消耗时每次调用消耗的次数
+const DefaultCost int = 1
+
+// DailyCost 今天天气、明天天气每次调用消耗的次数，逐日预报的数据量比实时天气大
+const DailyCost int = 2
+
+// privatePool 私聊和频道共用的调用额度池，对应 GroupCode 为 0 的群设置
+const privatePool int64 = 0
+
+// quotaPool 当前场景使用的调用额度池，群聊为群号
+func quotaPool(ctx *commandContext) int64 {
+	if ctx.scope == scopeGroup {
+		return ctx.groupCode
+	}
+	return privatePool
+}
+
+// poolName 额度池名称，用于提示信息
+func poolName(pool int64) string {
+	if pool == privatePool {
+		return "私聊和频道"
+	}
+	return fmt.Sprintf("群「%d」", pool)
+}
+
+// poolBudget 额度池每日的调用额度，-1 表示不限
+// 群单独设置的额度优先，否则使用配置文件中的 group_limit 或 private_limit，配置为 0 时不限
+func poolBudget(pool int64) int {
+	if budget := groupSetting(pool).Budget; budget != 0 {
+		return budget
+	}
+	c := currentConfig()
+	budget := c.GroupLimit
+	if pool == privatePool {
+		budget = c.PrivateLimit
+	}
+	if budget <= 0 {
+		return -1
+	}
+	return budget
+}
+
+// budgetMessage 额度池的使用情况，如「群「123」今日已用 12 / 500 次」
+func budgetMessage(pool int64) string {
+	used, err := service.NewDBService(database.GetDB()).GetGroupTimes(pool, today())
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to get group times.")
+		return DatabaseErrorMessage
+	}
+	usage := fmt.Sprintf("%d 次，额度不限", used)
+	if budget := poolBudget(pool); budget >= 0 {
+		usage = fmt.Sprintf("%d / %d 次", used, budget)
+	}
+	if groupSetting(pool).Budget == 0 {
+		usage += "（全局设置）"
+	}
+	return fmt.Sprintf("%s今日已用 %s", poolName(pool), usage)
+}
+
+// setBudget 查看或修改额度池的每日额度
+// target 为群号或 private，为空时群聊中为当前群、私聊中为私聊额度池；value 为空时只查看
+func setBudget(ctx *commandContext, target, value string) string {
+	pool := quotaPool(ctx)
+	switch target {
+	case "":
+	case "private":
+		pool = privatePool
+	default:
+		groupCode, err := parseUin(target)
+		if err != nil {
+			return fmt.Sprintf("解析失败，「%s」不是正确的群号。", target)
+		}
+		pool = groupCode
+	}
+	if value == "" {
+		return budgetMessage(pool) + "。"
+	}
+	var budget int
+	switch value {
+	case "default", "默认":
+		budget = 0
+	case "unlimited", "不限":
+		budget = -1
+	default:
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Sprintf("解析失败，「%s」不是正确的额度，请使用正整数、default 或 unlimited。", value)
+		}
+		budget = n
+	}
+	if err := service.NewDBService(database.GetDB()).UpdateGroupSetting(pool, "budget", budget); err != nil {
+		logger.WithError(err).Errorf("Fail to update group budget.")
+		return DatabaseErrorMessage
+	}
+	invalidateGroupSetting(pool)
+	return "设置成功，" + budgetMessage(pool) + "。"
+}
+
+// reservation 预扣的调用次数，查询成功后保留，失败时退还
+type reservation struct {
+	uin  int64
+	pool int64
+	date string
+	cost int
+}
+
+// reserveQuota 按指令的消耗预扣用户和额度池的调用次数，unlimited 等级的用户不受上限限制
+// 超出上限时不扣除，返回包含剩余次数的提示信息
+func reserveQuota(ctx *commandContext, tier Tier) (*reservation, string) {
+	uin := ctx.sender.Uin
+	r := &reservation{uin: uin, pool: quotaPool(ctx), date: today(), cost: ctx.cost}
+	if r.cost <= 0 {
+		r.cost = DefaultCost
+	}
+	limit, budget := userLimit(ctx, tier), poolBudget(r.pool)
+	if tier.Unlimited {
+		budget = -1
+	}
+	dbService := service.NewDBService(database.GetDB())
+	ok, err := dbService.ReserveUserTimes(uin, r.date, r.cost, limit)
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to reserve user times.")
+		return nil, DatabaseErrorMessage
+	}
+	if !ok {
+		times, err := dbService.GetUserTimes(uin, r.date)
+		if err != nil {
+			logger.WithError(err).Errorf("Fail to get user times.")
+			return nil, DatabaseErrorMessage
+		}
+		return nil, fmt.Sprintf("彩云天气为付费 api，万次 8 元，为防止滥用，当前每人每日使用次数上限为 %d 次，%s", limit, remainingText(limit-times, r.cost))
+	}
+	ok, err = dbService.ReserveGroupTimes(r.pool, r.date, r.cost, budget)
+	if err != nil || !ok {
+		if err := dbService.RefundUserTimes(uin, r.date, r.cost); err != nil {
+			logger.WithError(err).Errorf("Fail to refund user times.")
+		}
+	}
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to reserve group times.")
+		return nil, DatabaseErrorMessage
+	}
+	if !ok {
+		times, err := dbService.GetGroupTimes(r.pool, r.date)
+		if err != nil {
+			logger.WithError(err).Errorf("Fail to get group times.")
+			return nil, DatabaseErrorMessage
+		}
+		name := "本群"
+		if r.pool == privatePool {
+			name = "私聊和频道"
+		}
+		return nil, fmt.Sprintf("%s每日的天气查询额度为 %d 次，%s", name, budget, remainingText(budget-times, r.cost))
+	}
+	return r, ""
+}
+
+// refund 查询失败时退还预扣的调用次数
+func (r *reservation) refund() {
+	dbService := service.NewDBService(database.GetDB())
+	if err := dbService.RefundUserTimes(r.uin, r.date, r.cost); err != nil {
+		logger.WithError(err).Errorf("Fail to refund user times.")
+	}
+	if err := dbService.RefundGroupTimes(r.pool, r.date, r.cost); err != nil {
+		logger.WithError(err).Errorf("Fail to refund group times.")
+	}
+}
+
+// remainingText 剩余次数不足时的说明
+func remainingText(remaining, cost int) string {
+	if remaining <= 0 {
+		return "今日已用完，明天再来吧。"
+	}
+	return fmt.Sprintf("今日剩余 %d 次，不足本次查询所需的 %d 次。", remaining, cost)
+}
@@ -0,0 +1,140 @@
+package weather
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// rateLimiter 内存中的查询频率限制，记录每个用户和每个群最近的查询时间
+// 重启后记录清空，每日次数上限仍由数据库中的调用次数控制
+type rateLimiter struct {
+	sync.Mutex
+	users   map[int64][]time.Time
+	groups  map[int64][]time.Time
+	notices map[string]time.Time // 上次发送限流提示的时间，同一窗口内只提示一次
+}
+
+var limiter = &rateLimiter{
+	users:   make(map[int64][]time.Time),
+	groups:  make(map[int64][]time.Time),
+	notices: make(map[string]time.Time),
+}
+
+// allow 检查当前查询是否超出频率限制，未超出时记录本次查询
+// 用户的查询间隔和窗口内次数按等级设置，超出限制时返回提示信息，同一限制在一个窗口内已经提示过时返回空字符串，不再回复
+func (l *rateLimiter) allow(ctx *commandContext, tier Tier, now time.Time) (string, bool) {
+	l.Lock()
+	defer l.Unlock()
+	rate := currentConfig().RateLimit
+	if tier.Cooldown != 0 {
+		rate.Cooldown = tier.Cooldown
+	}
+	if tier.WindowLimit != 0 {
+		rate.WindowLimit = tier.WindowLimit
+	}
+	uin := ctx.sender.Uin
+	userWindow := time.Duration(rate.Window) * time.Second
+	cooldown := time.Duration(rate.Cooldown) * time.Second
+	history := recent(l.users[uin], now, maxDuration(userWindow, cooldown))
+	l.users[uin] = history
+	if cooldown > 0 && len(history) > 0 {
+		if wait := history[len(history)-1].Add(cooldown).Sub(now); wait > 0 {
+			return l.notice(fmt.Sprintf("cooldown:%d", uin), now, cooldown,
+				fmt.Sprintf("查询太频繁了，请 %s后再试。", waitText(wait))), false
+		}
+	}
+	if rate.WindowLimit > 0 && userWindow > 0 {
+		if inWindow := recent(history, now, userWindow); len(inWindow) >= rate.WindowLimit {
+			wait := inWindow[0].Add(userWindow).Sub(now)
+			return l.notice(fmt.Sprintf("window:%d", uin), now, userWindow,
+				fmt.Sprintf("%s内最多查询 %d 次，请 %s后再试。", waitText(userWindow), rate.WindowLimit, waitText(wait))), false
+		}
+	}
+	groupWindow := time.Duration(rate.GroupWindow) * time.Second
+	if ctx.scope == scopeGroup && rate.GroupBurst > 0 && groupWindow > 0 {
+		groupHistory := recent(l.groups[ctx.groupCode], now, groupWindow)
+		l.groups[ctx.groupCode] = groupHistory
+		if len(groupHistory) >= rate.GroupBurst {
+			return l.notice(fmt.Sprintf("group:%d", ctx.groupCode), now, groupWindow,
+				fmt.Sprintf("本群查询太频繁了，%s内最多查询 %d 次，请稍后再试。", waitText(groupWindow), rate.GroupBurst)), false
+		}
+		l.groups[ctx.groupCode] = append(groupHistory, now)
+	}
+	l.users[uin] = append(history, now)
+	return "", true
+}
+
+// notice 同一限制在 window 内只返回一次提示信息
+func (l *rateLimiter) notice(key string, now time.Time, window time.Duration, text string) string {
+	if last, ok := l.notices[key]; ok && now.Sub(last) < window {
+		return ""
+	}
+	l.notices[key] = now
+	for k, v := range l.notices {
+		if now.Sub(v) >= time.Hour {
+			delete(l.notices, k)
+		}
+	}
+	return text
+}
+
+// prune 删除已经超出窗口的记录，只查询过一次的用户和群不会一直占用内存
+// 窗口按配置和各等级中最长的计算，由每日清零的定时任务调用
+func (l *rateLimiter) prune(now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+	c := currentConfig()
+	seconds := c.RateLimit.Cooldown
+	if c.RateLimit.Window > seconds {
+		seconds = c.RateLimit.Window
+	}
+	for _, tiers := range []map[string]Tier{builtinTiers, c.Tiers} {
+		for _, tier := range tiers {
+			if tier.Cooldown > seconds {
+				seconds = tier.Cooldown
+			}
+		}
+	}
+	userWindow := time.Duration(seconds) * time.Second
+	groupWindow := time.Duration(c.RateLimit.GroupWindow) * time.Second
+	for uin, history := range l.users {
+		if len(recent(history, now, userWindow)) == 0 {
+			delete(l.users, uin)
+		}
+	}
+	for groupCode, history := range l.groups {
+		if len(recent(history, now, groupWindow)) == 0 {
+			delete(l.groups, groupCode)
+		}
+	}
+}
+
+// recent 保留 window 内的查询时间，window 为 0 时全部丢弃
+func recent(history []time.Time, now time.Time, window time.Duration) []time.Time {
+	i := 0
+	for i < len(history) && now.Sub(history[i]) >= window {
+		i++
+	}
+	if i == len(history) {
+		return nil
+	}
+	return history[i:]
+}
+
+// maxDuration 两个时长中较长的一个
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// waitText 时长的中文描述，如「10 分钟」「30 秒」，超过一分钟时向上取整到分钟
+func waitText(d time.Duration) string {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds >= 60 {
+		return fmt.Sprintf("%d 分钟", (seconds+59)/60)
+	}
+	return fmt.Sprintf("%d 秒", seconds)
+}
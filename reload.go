@@ -0,0 +1,161 @@
+package weather
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Logiase/MiraiGo-Template/bot"
+	"github.com/Logiase/MiraiGo-Template/config"
+	"github.com/fsnotify/fsnotify"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/geo"
+)
+
+// reloadDelay 配置文件变化后等待多久再重新加载，编辑器保存时可能连续触发多次事件
+const reloadDelay = time.Second
+
+// serveBot Serve 时的 bot，重新加载配置时用于重建定时任务
+var serveBot *bot.Bot
+
+// configFile 配置文件状态，重新加载和写回配置文件互斥进行
+var configFile = struct {
+	sync.Mutex
+	hash    [sha256.Size]byte // 最近一次读取或写入的内容，内容没有变化时不重新加载
+	watcher *fsnotify.Watcher
+}{}
+
+// configPath 配置文件路径
+func configPath() string {
+	path := config.GlobalConfig.GetString("aimerneige.weather.path")
+	if path == "" {
+		path = "./weather.yaml"
+	}
+	return path
+}
+
+// reloadConfig 重新加载配置文件，配置有误时保留当前配置
+// force 为 false 时文件内容没有变化则跳过；返回加载结果，包括需要重启才能生效的改动
+func reloadConfig(force bool) (string, error) {
+	configFile.Lock()
+	defer configFile.Unlock()
+	path := configPath()
+	newConfig, data, problems, err := loadConfigFile(path)
+	if err != nil {
+		return "", fmt.Errorf("读取配置文件失败：%v", err)
+	}
+	hash := sha256.Sum256(data)
+	if !force && hash == configFile.hash {
+		return "", nil
+	}
+	if len(problems) > 0 {
+		return "", fmt.Errorf("配置文件有误，未重新加载：\n%s", strings.Join(problems, "\n"))
+	}
+	oldConfig := currentConfig().Config
+	setConfig(newConfig)
+	configFile.hash = hash
+	notes := applyConfig(oldConfig, newConfig)
+	lines := append([]string{fmt.Sprintf("配置文件已重新加载，定时推送 %d 个。", len(newConfig.Daily))}, notes...)
+	return strings.Join(lines, "\n"), nil
+}
+
+// applyConfig 让新配置生效：触发词、地名数据和定时任务，返回需要提示的注意事项
+func applyConfig(oldConfig, newConfig Config) []string {
+	var notes []string
+	_ = configureTriggers(newConfig.Commands.Prefix, newConfig.Commands.Triggers)
+	if newConfig.Geocode.Path != oldConfig.Geocode.Path {
+		if newConfig.Geocode.Path == "" {
+			geocoder.Store(defaultGeocoder)
+		} else if g, err := geo.LoadGeocoder(newConfig.Geocode.Path); err != nil {
+			logger.WithError(err).Errorf("Unable to load geocoder data in %s, keep the current data", newConfig.Geocode.Path)
+			notes = append(notes, "地名数据加载失败，继续使用之前的数据。")
+		} else {
+			geocoder.Store(g)
+		}
+	}
+	if serveBot != nil {
+		scheduleJobs(serveBot)
+	}
+	if newConfig.DB != oldConfig.DB {
+		notes = append(notes, "数据库配置需要重启后生效。")
+	}
+	if len(newConfig.Admin)+len(newConfig.Allowed)+len(newConfig.BlackList)+len(newConfig.WhiteList) > 0 {
+		notes = append(notes, "配置文件中的名单不会生效，请使用管理员指令修改。")
+	}
+	return notes
+}
+
+// reloadHandler 「.weather.reload」重新加载配置文件
+func reloadHandler(ctx *commandContext) string {
+	result, err := reloadConfig(true)
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to reload config file.")
+		return err.Error()
+	}
+	logger.Infof("Config file reloaded by %d.", ctx.sender.Uin)
+	return result
+}
+
+// watchConfig 监听配置文件变化并自动重新加载
+// 监听配置文件所在的目录，编辑器通过重命名保存文件时也能收到事件
+func watchConfig() {
+	path, err := filepath.Abs(configPath())
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to watch config file.")
+		return
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to watch config file.")
+		return
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		logger.WithError(err).Errorf("Fail to watch config file.")
+		watcher.Close()
+		return
+	}
+	configFile.Lock()
+	configFile.watcher = watcher
+	configFile.Unlock()
+	var timer *time.Timer
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != path || event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(reloadDelay, func() {
+				result, err := reloadConfig(false)
+				switch {
+				case err != nil:
+					logger.WithError(err).Errorf("Fail to reload config file.")
+				case result != "":
+					logger.Info("Config file reloaded.")
+				}
+			})
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.WithError(err).Errorf("Config file watcher error.")
+		}
+	}
+}
+
+// stopWatchConfig 停止监听配置文件
+func stopWatchConfig() {
+	configFile.Lock()
+	defer configFile.Unlock()
+	if configFile.watcher != nil {
+		configFile.watcher.Close()
+		configFile.watcher = nil
+	}
+}
@@ -0,0 +1,103 @@
+package weather
+
+import (
+	"time"
+
+	"github.com/Logiase/MiraiGo-Template/bot"
+	"github.com/Mrs4s/MiraiGo/message"
+	"github.com/go-co-op/gocron"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/database"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/service"
+)
+
+// 定时任务的标签，重新加载配置时按标签重建
+const (
+	resetJobTag = "reset"
+	dailyJobTag = "daily"
+)
+
+// resetScheduler 清零调用次数的定时任务，按清零时区执行
+var resetScheduler = gocron.NewScheduler(resetLocation())
+
+// dailyScheduler 定时推送天气的定时任务，daily.time 按 UTC 计算
+var dailyScheduler = gocron.NewScheduler(time.UTC)
+
+// scheduleJobs 按当前配置创建全部定时任务，已有的任务会先移除
+func scheduleJobs(b *bot.Bot) {
+	_ = resetScheduler.RemoveByTag(resetJobTag)
+	resetScheduler.ChangeLocation(resetLocation())
+	scheduleReset(b)
+	_ = dailyScheduler.RemoveByTag(dailyJobTag)
+	for _, d := range currentConfig().Daily {
+		scheduleDaily(b, d)
+	}
+}
+
+// scheduleReset 每天 00:00 清零调用次数，同时清理过期的查询频率记录
+// 调用次数在首次使用时按日期清零，定时任务只是提前清零，错过也不影响
+func scheduleReset(b *bot.Bot) {
+	_, err := resetScheduler.Every(1).Day().At("00:00").Tag(resetJobTag).Do(func() {
+		dbService := service.NewDBService(database.GetDB())
+		err := dbService.ClearAllUserTimes()
+		if err != nil {
+			logger.WithError(err).Errorf("Fail to clear user times.")
+			for i := 0; i < 3 && err != nil; i++ {
+				err = dbService.ClearAllUserTimes()
+				logger.WithError(err).Errorf("Fail to clear user times. The %d times to retry.", i)
+			}
+			if err != nil {
+				// 重试三次依然错误，发送消息提示管理员
+				for _, admin := range listIDs(listAdmins) {
+					msg := message.NewSendingMessage().Append(message.NewText("服务器又挂掉啦~\n错误信息：\n无法清空用户次数。"))
+					b.SendPrivateMessage(admin, msg)
+				}
+			}
+		}
+		if err := dbService.ClearAllGroupTimes(); err != nil {
+			logger.WithError(err).Errorf("Fail to clear group times.")
+		}
+		limiter.prune(time.Now())
+	})
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to schedule reset job.")
+	}
+}
+
+// scheduleDaily 创建一个定时推送任务
+func scheduleDaily(b *bot.Bot, d DailyConfig) {
+	_, err := dailyScheduler.Every(1).Day().At(d.Time).Tag(dailyJobTag).Do(func() {
+		// 群关闭了天气预报功能时不推送
+		if d.ChannelID == 0 && !groupFeatureEnabled(d.GroupCode, featureDaily) {
+			return
+		}
+		caiyunAPI := service.NewCaiyun(currentConfig().Key)
+		weatherString := ""
+		switch d.Type {
+		case "today":
+			weatherString, _ = caiyunAPI.Today(d.Longitude, d.Latitude)
+		case "tomorrow":
+			weatherString, _ = caiyunAPI.Tomorrow(d.Longitude, d.Latitude)
+		default:
+			weatherString = "配置文件错误，请检查"
+		}
+		if weatherString == "" {
+			return
+		}
+		notify := d.Notify
+		if notify == "" {
+			notify = dailyTitle(d.Type, d.Longitude, d.Latitude)
+		}
+		replyMsgString := notify + "\n" + weatherString
+		msg := message.NewSendingMessage().Append(message.NewText(replyMsgString))
+		if d.ChannelID != 0 {
+			if _, err := b.GuildService.SendGuildChannelMessage(d.GuildID, d.ChannelID, msg); err != nil {
+				logger.WithError(err).Errorf("Fail to send daily weather to guild channel %d.", d.ChannelID)
+			}
+			return
+		}
+		b.SendGroupMessage(d.GroupCode, msg)
+	})
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to schedule daily weather at %s.", d.Time)
+	}
+}
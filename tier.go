@@ -0,0 +1,162 @@
+package weather
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/database"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/database/model"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/service"
+	"gorm.io/gorm"
+)
+
+// 内置的用户等级
+const (
+	tierDefault   = "default"
+	tierTrusted   = "trusted"
+	tierVIP       = "vip"
+	tierUnlimited = "unlimited"
+)
+
+// Tier 用户等级的调用限制，0 表示使用全局设置，-1 表示不限
+type Tier struct {
+	Limit       int  `yaml:"limit"`        // 每人每日调用次数上限
+	Cooldown    int  `yaml:"cooldown"`     // 两次查询的最小间隔（秒）
+	WindowLimit int  `yaml:"window_limit"` // 滑动窗口内最多查询次数
+	Unlimited   bool `yaml:"unlimited"`    // 不受调用次数、额度和频率限制
+}
+
+// builtinTierNames 内置等级的显示顺序
+var builtinTierNames = []string{tierDefault, tierTrusted, tierVIP, tierUnlimited}
+
+// builtinTiers 内置等级的默认限制，可以在配置文件的 tiers 中覆盖
+var builtinTiers = map[string]Tier{
+	tierDefault:   {},
+	tierTrusted:   {Limit: 30, WindowLimit: 6},
+	tierVIP:       {Limit: 100, WindowLimit: 10},
+	tierUnlimited: {Unlimited: true},
+}
+
+// findTier 按名称查找等级，配置文件中的设置优先
+func findTier(name string) (Tier, bool) {
+	if tier, ok := currentConfig().Tiers[name]; ok {
+		return tier, true
+	}
+	tier, ok := builtinTiers[name]
+	return tier, ok
+}
+
+// tierNames 全部等级名称，内置等级在前
+func tierNames() []string {
+	names := append([]string{}, builtinTierNames...)
+	var custom []string
+	for name := range currentConfig().Tiers {
+		if _, ok := builtinTiers[name]; !ok {
+			custom = append(custom, name)
+		}
+	}
+	sort.Strings(custom)
+	return append(names, custom...)
+}
+
+// userTier 用户的等级，白名单用户为 unlimited，未设置或等级已从配置中删除时为 default
+func userTier(user model.User) (string, Tier) {
+	if inWhitelist(user.Uin) {
+		return tierUnlimited, builtinTiers[tierUnlimited]
+	}
+	if tier, ok := findTier(user.Tier); ok && user.Tier != "" {
+		return user.Tier, tier
+	}
+	tier, _ := findTier(tierDefault)
+	return tierDefault, tier
+}
+
+// userLimit 用户在当前场景的每日调用次数上限，-1 表示不限
+// 等级设置了上限时优先使用，否则使用群设置或全局设置
+func userLimit(ctx *commandContext, tier Tier) int {
+	switch {
+	case tier.Unlimited || tier.Limit < 0:
+		return -1
+	case tier.Limit > 0:
+		return tier.Limit
+	}
+	return dailyLimit(ctx)
+}
+
+// tierDescription 等级限制的说明，如「每日 30 次，10 分钟内最多 6 次」
+func tierDescription(tier Tier) string {
+	if tier.Unlimited {
+		return "不限次数"
+	}
+	c := currentConfig()
+	var parts []string
+	switch {
+	case tier.Limit < 0:
+		parts = append(parts, "每日不限次数")
+	case tier.Limit > 0:
+		parts = append(parts, fmt.Sprintf("每日 %d 次", tier.Limit))
+	default:
+		parts = append(parts, fmt.Sprintf("每日默认 %d 次", c.Limit))
+	}
+	rate := c.RateLimit
+	if tier.WindowLimit != 0 {
+		rate.WindowLimit = tier.WindowLimit
+	}
+	if tier.Cooldown != 0 {
+		rate.Cooldown = tier.Cooldown
+	}
+	if rate.WindowLimit > 0 && rate.Window > 0 {
+		parts = append(parts, fmt.Sprintf("%s内最多 %d 次", waitText(time.Duration(rate.Window)*time.Second), rate.WindowLimit))
+	}
+	if rate.Cooldown > 0 {
+		parts = append(parts, fmt.Sprintf("查询间隔 %d 秒", rate.Cooldown))
+	}
+	return strings.Join(parts, "，")
+}
+
+// setUserTier 查看或修改用户的等级，tier 为空时只查看
+func setUserTier(uin int64, tier string) string {
+	dbService := service.NewDBService(database.GetDB())
+	user, err := dbService.GetUser(uin)
+	if err == gorm.ErrRecordNotFound {
+		return fmt.Sprintf("用户「%d」还没有保存地址，保存地址后才能设置等级。", uin)
+	}
+	if err != nil {
+		logger.WithError(err).Errorf("Fail to get user.")
+		return DatabaseErrorMessage
+	}
+	if tier == "" {
+		name, t := userTier(user)
+		return fmt.Sprintf("用户「%s」的等级为 %s（%s）。\n%s", userDisplayName(user), name, tierDescription(t), tierListMessage())
+	}
+	tier = strings.ToLower(tier)
+	if _, ok := findTier(tier); !ok {
+		return fmt.Sprintf("未知等级「%s」。\n%s", tier, tierListMessage())
+	}
+	if tier == tierDefault {
+		tier = ""
+	}
+	if err := dbService.UpdateUserTier(uin, tier); err != nil {
+		logger.WithError(err).Errorf("Fail to update user tier.")
+		return DatabaseErrorMessage
+	}
+	user.Tier = tier
+	name, t := userTier(user)
+	reply := fmt.Sprintf("设置成功，用户「%s」的等级为 %s（%s）。", userDisplayName(user), name, tierDescription(t))
+	if inWhitelist(uin) {
+		reply += "\n该用户在白名单中，移出白名单前不受等级限制。"
+	}
+	return reply
+}
+
+// tierListMessage 全部等级及其限制
+func tierListMessage() string {
+	lines := []string{"可选等级："}
+	for _, name := range tierNames() {
+		tier, _ := findTier(name)
+		lines = append(lines, fmt.Sprintf("%s：%s", name, tierDescription(tier)))
+	}
+	return strings.Join(lines, "\n")
+}
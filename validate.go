@@ -0,0 +1,426 @@
+package weather
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Logiase/MiraiGo-Template/config"
+	"gopkg.in/yaml.v3"
+)
+
+// configProblems 配置检查发现的问题，每项为「YAML 路径：说明」
+type configProblems []string
+
+// add 记录一个问题
+func (p *configProblems) add(path, format string, args ...interface{}) {
+	*p = append(*p, path+"："+fmt.Sprintf(format, args...))
+}
+
+// strictConfig 是否为严格模式，严格模式下配置文件有误时拒绝启动
+// 在 application.yaml 中设置 aimerneige.weather.strict
+func strictConfig() bool {
+	return config.GlobalConfig.GetBool("aimerneige.weather.strict")
+}
+
+// loadConfigFile 读取、解析并检查配置文件
+// 文件无法读取或不是合法的 YAML 时返回错误；其他问题在 problems 中列出，此时 Config 依然可用，但部分功能可能异常
+func loadConfigFile(path string) (Config, []byte, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, nil, nil, err
+	}
+	c, problems, err := parseConfig(data)
+	return c, data, problems, err
+}
+
+// parseConfig 解析并检查配置文件的内容，展开 ${ENV} 环境变量并读取 *_file 指定的密钥
+// 不是合法的 YAML 时返回错误，其他问题在 problems 中列出
+func parseConfig(data []byte) (Config, []string, error) {
+	var c Config
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return c, nil, err
+	}
+	if len(doc.Content) == 0 {
+		return c, validateConfig(c), nil
+	}
+	var problems configProblems
+	root := doc.Content[0]
+	expanded := make(map[string]string)
+	expandConfigEnv(&problems, expanded, root, "")
+	checkConfigSchema(&problems, root, reflect.TypeOf(c), "")
+	// 类型错误时其他项依然会解析，类型错误已经由 checkConfigSchema 带上路径列出
+	var typeErr *yaml.TypeError
+	if err := root.Decode(&c); err != nil && !errors.As(err, &typeErr) {
+		return c, nil, err
+	}
+	resolveSecretFiles(&problems, &c)
+	problems = append(problems, validateConfig(c)...)
+	return c, hideExpandedValues(problems, expanded), nil
+}
+
+// envPattern 配置中的环境变量，如 ${CAIYUN_KEY}
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandConfigEnv 展开配置值中的 ${ENV} 环境变量，环境变量未设置时记录问题并替换为空
+// 展开后的值和原始写法记录在 expanded 中，见 hideExpandedValues
+func expandConfigEnv(problems *configProblems, expanded map[string]string, node *yaml.Node, path string) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "${") {
+			return
+		}
+		raw := node.Value
+		node.Value = envPattern.ReplaceAllStringFunc(raw, func(s string) string {
+			name := envPattern.FindStringSubmatch(s)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				problems.add(path, "环境变量 %s 未设置", name)
+			}
+			return value
+		})
+		expanded[node.Value] = raw
+		// 没有引号的值按展开后的内容重新判断类型，如 limit: ${WEATHER_LIMIT}
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			expandConfigEnv(problems, expanded, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			expandConfigEnv(problems, expanded, node.Content[i+1], joinConfigPath(path, node.Content[i].Value))
+		}
+	}
+}
+
+// hideExpandedValues 把问题中引用的「值」换回展开前的 ${ENV} 写法，环境变量中的密钥不会出现在提示和日志中
+// 如 limit: ${CAIYUN_KEY} 提示为「${CAIYUN_KEY}」不是整数
+func hideExpandedValues(problems []string, expanded map[string]string) []string {
+	for i, problem := range problems {
+		for value, raw := range expanded {
+			if value != "" {
+				problem = strings.ReplaceAll(problem, "「"+value+"」", "「"+raw+"」")
+			}
+		}
+		problems[i] = problem
+	}
+	return problems
+}
+
+// resolveSecretFiles 读取 key_file、db.mysql.password_file 指定的密钥，去掉首尾空白
+func resolveSecretFiles(problems *configProblems, c *Config) {
+	for _, secret := range []struct {
+		path  string
+		value *string
+		file  string
+	}{
+		{"key", &c.Key, c.KeyFile},
+		{"db.mysql.password", &c.DB.MySQL.Password, c.DB.MySQL.PasswordFile},
+	} {
+		if secret.file == "" {
+			continue
+		}
+		if *secret.value != "" {
+			problems.add(secret.path+"_file", "不能与 %s 同时填写", secret.path)
+			continue
+		}
+		data, err := os.ReadFile(secret.file)
+		if err != nil {
+			problems.add(secret.path+"_file", "无法读取「%s」", secret.file)
+			continue
+		}
+		*secret.value = strings.TrimSpace(string(data))
+	}
+}
+
+// yamlLinePattern YAML 错误信息开头的行号
+var yamlLinePattern = regexp.MustCompile(`^line (\d+): `)
+
+// yamlLineMessage 把「line 3: ...」形式的 YAML 错误信息改为「第 3 行：...」
+func yamlLineMessage(msg string) string {
+	return yamlLinePattern.ReplaceAllString(msg, "第 $1 行：")
+}
+
+// checkConfigSchema 按 Config 的结构检查配置文件的节点树
+// 列出 Config 没有的配置项（通常是拼写或缩进错误）和类型错误的值
+func checkConfigSchema(problems *configProblems, node *yaml.Node, t reflect.Type, path string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+	where := path
+	if where == "" {
+		where = "配置文件"
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			problems.add(where, "应为键值对（第 %d 行）", node.Line)
+			return
+		}
+		fields := make(map[string]reflect.Type, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if name != "" && name != "-" {
+				fields[name] = t.Field(i).Type
+			}
+		}
+		seen := make(map[string]bool, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if seen[key] {
+				problems.add(joinConfigPath(path, key), "重复的配置项（第 %d 行）", node.Content[i].Line)
+				continue
+			}
+			seen[key] = true
+			field, ok := fields[key]
+			if !ok {
+				problems.add(joinConfigPath(path, key), "未知的配置项（第 %d 行），请检查拼写和缩进", node.Content[i].Line)
+				continue
+			}
+			checkConfigSchema(problems, node.Content[i+1], field, joinConfigPath(path, key))
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			problems.add(where, "应为列表（第 %d 行）", node.Line)
+			return
+		}
+		for i, item := range node.Content {
+			checkConfigSchema(problems, item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			problems.add(where, "应为键值对（第 %d 行）", node.Line)
+			return
+		}
+		seen := make(map[string]bool, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if seen[key] {
+				problems.add(joinConfigPath(path, key), "重复的配置项（第 %d 行）", node.Content[i].Line)
+				continue
+			}
+			seen[key] = true
+			checkConfigSchema(problems, node.Content[i+1], t.Elem(), joinConfigPath(path, key))
+		}
+	default:
+		if node.Kind != yaml.ScalarNode || node.Decode(reflect.New(t).Interface()) != nil {
+			problems.add(where, "「%s」不是%s（第 %d 行）", node.Value, scalarTypeName(t), node.Line)
+		}
+	}
+}
+
+// scalarTypeName 配置项类型的说明
+func scalarTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "整数"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "非负整数"
+	case reflect.Float32, reflect.Float64:
+		return "数字"
+	case reflect.Bool:
+		return "true 或 false"
+	}
+	return "文本"
+}
+
+// joinConfigPath 拼接 YAML 路径，如 db.mysql.host
+func joinConfigPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// validateConfig 检查配置中会导致功能异常的错误，返回全部问题
+func validateConfig(c Config) []string {
+	var problems configProblems
+	if c.Key == "" {
+		problems.add("key", "不能为空，请填写彩云天气的 api key，也可以使用 key_file 或 ${ENV} 环境变量")
+	}
+	switch {
+	case c.Limit == 0:
+		problems.add("limit", "为 0 时所有用户都无法查询，不限次数请填写 -1")
+	case c.Limit < -1:
+		problems.add("limit", "不能小于 -1")
+	}
+	if c.ResetTimezone != "" {
+		if _, err := time.LoadLocation(c.ResetTimezone); err != nil {
+			problems.add("reset_timezone", "「%s」不是正确的时区，如 Asia/Shanghai", c.ResetTimezone)
+		}
+	}
+	if c.GroupLimit < 0 {
+		problems.add("group_limit", "不能小于 0，0 表示不限")
+	}
+	if c.PrivateLimit < 0 {
+		problems.add("private_limit", "不能小于 0，0 表示不限")
+	}
+	switch c.DB.Type {
+	case "mysql":
+		mysql := c.DB.MySQL
+		for _, field := range []struct{ name, value string }{
+			{"host", mysql.Host},
+			{"username", mysql.Username},
+			{"database", mysql.Database},
+		} {
+			if field.value == "" {
+				problems.add("db.mysql."+field.name, "不能为空")
+			}
+		}
+		if mysql.Port != "" {
+			if port, err := strconv.Atoi(mysql.Port); err != nil || port <= 0 || port > 65535 {
+				problems.add("db.mysql.port", "「%s」不是正确的端口", mysql.Port)
+			}
+		}
+	case "sqlite":
+		if c.DB.SQLite.Path == "" {
+			problems.add("db.sqlite.path", "不能为空")
+		}
+	default:
+		problems.add("db.type", "「%s」只能是 mysql 或 sqlite", c.DB.Type)
+	}
+	for i, d := range c.Daily {
+		path := fmt.Sprintf("daily[%d]", i)
+		if d.GroupCode == 0 && (d.GuildID == 0 || d.ChannelID == 0) {
+			problems.add(path, "需要填写 group，或者同时填写 guild 和 channel")
+		}
+		if _, err := time.Parse("15:04", d.Time); err != nil {
+			if _, err := time.Parse("15:04:05", d.Time); err != nil {
+				problems.add(path+".time", "「%s」不是正确的时间，格式为 HH:MM（UTC 时区）", d.Time)
+			}
+		}
+		if d.Type != "today" && d.Type != "tomorrow" {
+			problems.add(path+".type", "「%s」只能是 today 或 tomorrow", d.Type)
+		}
+		if d.Longitude < -180 || d.Longitude > 180 {
+			problems.add(path+".longitude", "%v 超出范围，经度应在 -180 到 180 之间", d.Longitude)
+		}
+		if d.Latitude < -90 || d.Latitude > 90 {
+			problems.add(path+".latitude", "%v 超出范围，纬度应在 -90 到 90 之间", d.Latitude)
+		}
+		if d.Longitude == 0 && d.Latitude == 0 {
+			problems.add(path, "没有填写 longitude 和 latitude")
+		}
+	}
+	if c.Geocode.Path != "" {
+		if _, err := os.Stat(c.Geocode.Path); err != nil {
+			problems.add("geocode.path", "无法读取「%s」", c.Geocode.Path)
+		}
+	}
+	if err := checkTriggers(c.Commands.Prefix, c.Commands.Triggers); err != nil {
+		problems.add("commands", "%v", err)
+	}
+	for i, v := range c.Guild.Allowed {
+		if v.GuildID == 0 {
+			problems.add(fmt.Sprintf("guild.allowed[%d].guild", i), "不能为空")
+		}
+	}
+	for i, g := range c.Reply.Groups {
+		if g.GroupCode == 0 {
+			problems.add(fmt.Sprintf("reply.groups[%d].group", i), "不能为空")
+		}
+	}
+	if c.Dedupe.Window < 0 {
+		problems.add("dedupe.window", "不能小于 0，0 表示不去重")
+	}
+	names := make([]string, 0, len(c.Tiers))
+	for name := range c.Tiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tier, path := c.Tiers[name], "tiers."+name
+		if tier.Limit < -1 {
+			problems.add(path+".limit", "不能小于 -1")
+		}
+		if tier.Cooldown < -1 {
+			problems.add(path+".cooldown", "不能小于 -1")
+		}
+		if tier.WindowLimit < -1 {
+			problems.add(path+".window_limit", "不能小于 -1")
+		}
+	}
+	rate := c.RateLimit
+	for _, field := range []struct {
+		name  string
+		value int
+	}{
+		{"cooldown", rate.Cooldown},
+		{"window", rate.Window},
+		{"window_limit", rate.WindowLimit},
+		{"group_window", rate.GroupWindow},
+		{"group_burst", rate.GroupBurst},
+	} {
+		if field.value < 0 {
+			problems.add("rate_limit."+field.name, "不能小于 0")
+		}
+	}
+	if rate.WindowLimit > 0 && rate.Window <= 0 {
+		problems.add("rate_limit.window", "设置了 window_limit 时需要大于 0")
+	}
+	if rate.GroupBurst > 0 && rate.GroupWindow <= 0 {
+		problems.add("rate_limit.group_window", "设置了 group_burst 时需要大于 0")
+	}
+	return problems
+}
+
+// checkConfigHandler 「.weather.config.check」检查配置文件，不指定路径时检查当前的配置文件
+// 可以在替换配置文件或者重新加载之前先检查新的配置；只能检查配置文件所在目录内的文件
+func checkConfigHandler(ctx *commandContext) string {
+	path := ctx.str("路径")
+	if path == "" {
+		path = configPath()
+	}
+	file, ok := configCheckPath(path)
+	if !ok {
+		return fmt.Sprintf("只能检查配置文件所在目录内的文件，「%s」不在该目录中。", path)
+	}
+	_, _, problems, err := loadConfigFile(file)
+	if err != nil {
+		return fmt.Sprintf("无法读取配置文件「%s」：%s", path, yamlLineMessage(strings.TrimPrefix(err.Error(), "yaml: ")))
+	}
+	if len(problems) == 0 {
+		return fmt.Sprintf("配置文件「%s」检查通过。", path)
+	}
+	return fmt.Sprintf("配置文件「%s」有 %d 个问题：\n%s", path, len(problems), strings.Join(problems, "\n"))
+}
+
+// configCheckPath 要检查的文件的实际路径，相对路径按配置文件所在目录计算
+// 文件不在配置文件所在目录内（包括通过符号链接指向目录外）时返回 false，避免通过错误信息读取服务器上的其他文件
+func configCheckPath(name string) (string, bool) {
+	dir, err := filepath.Abs(filepath.Dir(configPath()))
+	if err != nil {
+		return "", false
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	rel, err := filepath.Rel(dir, filepath.Clean(path))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return path, true
+}
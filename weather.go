@@ -1,63 +1,111 @@
 package weather
 
 import (
+	"crypto/sha256"
+	"errors"
 	"fmt"
-	"os"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/Logiase/MiraiGo-Template/bot"
-	"github.com/Logiase/MiraiGo-Template/config"
 	"github.com/Logiase/MiraiGo-Template/utils"
 	"github.com/Mrs4s/MiraiGo/client"
 	"github.com/Mrs4s/MiraiGo/message"
-	"github.com/go-co-op/gocron"
 	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/database"
+	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/geo"
 	"github.com/yukichan-bot-module/MiraiGo-module-weather/internal/service"
-	"gopkg.in/yaml.v3"
 	"gorm.io/gorm"
 )
 
 // Config 模块配置
 type Config struct {
-	Key       string  `yaml:"key"`
-	Limit     int     `yaml:"limit"`
-	Admin     []int64 `yaml:"admin"`
-	Allowed   []int64 `yaml:"allowed"`
-	BlackList []int64 `yaml:"blacklist"`
-	WhiteList []int64 `yaml:"whitelist"`
-	DB        struct {
+	Key           string  `yaml:"key"`
+	KeyFile       string  `yaml:"key_file"` // 从文件读取 key，不能与 key 同时填写
+	Limit         int     `yaml:"limit"`
+	ResetTimezone string  `yaml:"reset_timezone"` // 每日调用次数清零使用的时区，默认为 Asia/Shanghai
+	GroupLimit    int     `yaml:"group_limit"`    // 每个群每日调用额度，0 表示不限
+	PrivateLimit  int     `yaml:"private_limit"`  // 私聊和频道共用的每日调用额度，0 表示不限
+	Admin         []int64 `yaml:"admin"`
+	Allowed       []int64 `yaml:"allowed"`
+	BlackList     []int64 `yaml:"blacklist"`
+	WhiteList     []int64 `yaml:"whitelist"`
+	DB            struct {
 		Type  string `yaml:"type"`
 		MySQL struct {
-			Username string `yaml:"username"`
-			Password string `yaml:"password"`
-			Host     string `yaml:"host"`
-			Port     string `yaml:"port"`
-			Database string `yaml:"database"`
-			Charset  string `yaml:"charset"`
+			Username     string `yaml:"username"`
+			Password     string `yaml:"password"`
+			PasswordFile string `yaml:"password_file"` // 从文件读取 password，不能与 password 同时填写
+			Host         string `yaml:"host"`
+			Port         string `yaml:"port"`
+			Database     string `yaml:"database"`
+			Charset      string `yaml:"charset"`
 		} `yaml:"mysql"`
 		SQLite struct {
 			Path string `yaml:"path"`
 		} `yaml:"sqlite"`
 	} `yaml:"db"`
-	Daily []struct {
-		GroupCode int64   `yaml:"group"`
-		Longitude float64 `yaml:"longitude"`
-		Latitude  float64 `yaml:"latitude"`
-		Time      string  `yaml:"time"`
-		Type      string  `yaml:"type"`
-		Notify    string  `yaml:"notify"`
-	} `yaml:"daily"`
+	Daily   []DailyConfig `yaml:"daily"`
+	Geocode struct {
+		Path string `yaml:"path"`
+	} `yaml:"geocode"`
+	Commands struct {
+		Prefix      string              `yaml:"prefix"`
+		Triggers    map[string][]string `yaml:"triggers"`
+		MentionOnly []int64             `yaml:"mention_only"`
+	} `yaml:"commands"`
+	Guild struct {
+		Allowed []struct {
+			GuildID   uint64 `yaml:"guild"`
+			ChannelID uint64 `yaml:"channel"` // 为 0 时允许频道内全部子频道
+		} `yaml:"allowed"`
+	} `yaml:"guild"`
+	Reply struct {
+		Quote  *bool `yaml:"quote"` // 未配置时默认引用
+		At     bool  `yaml:"at"`
+		Groups []struct {
+			GroupCode int64 `yaml:"group"`
+			Quote     *bool `yaml:"quote"`
+			At        *bool `yaml:"at"`
+		} `yaml:"groups"`
+	} `yaml:"reply"`
+	NaturalLanguage struct {
+		Private bool    `yaml:"private"`
+		Groups  []int64 `yaml:"groups"`
+	} `yaml:"natural_language"`
+	Dedupe struct {
+		Window int `yaml:"window"` // 群内相同地区的相同查询在多少秒内视为重复，0 表示不去重
+	} `yaml:"dedupe"`
+	Tiers     map[string]Tier `yaml:"tiers"` // 用户等级，可以覆盖内置的 default、trusted、vip、unlimited
+	RateLimit struct {
+		Cooldown    int `yaml:"cooldown"`     // 同一用户两次查询的最小间隔（秒），0 表示不限
+		Window      int `yaml:"window"`       // 每人查询次数的滑动窗口长度（秒）
+		WindowLimit int `yaml:"window_limit"` // 滑动窗口内每人最多查询次数，0 表示不限
+		GroupWindow int `yaml:"group_window"` // 每群查询次数的滑动窗口长度（秒）
+		GroupBurst  int `yaml:"group_burst"`  // 滑动窗口内每群最多查询次数，0 表示不限
+	} `yaml:"rate_limit"`
 }
 
+// DailyConfig 定时推送配置
+type DailyConfig struct {
+	GroupCode int64   `yaml:"group"`
+	GuildID   uint64  `yaml:"guild"`   // 推送到子频道时填写频道 ID
+	ChannelID uint64  `yaml:"channel"` // 推送到子频道时填写子频道 ID
+	Longitude float64 `yaml:"longitude"`
+	Latitude  float64 `yaml:"latitude"`
+	Time      string  `yaml:"time"`
+	Type      string  `yaml:"type"`
+	Notify    string  `yaml:"notify"`
+}
+
+// LocationFormatMessage 地址格式说明
+const LocationFormatMessage string = "解析失败，请检查格式。正确的格式：「修改地址 经度 纬度」，示例：「修改地址 101.6656 39.2072」。\n也支持「纬度, 经度」、度分秒（如「39°54'15\"N 116°24'27\"E」）和 N/S/E/W 标记；从高德、腾讯地图复制的坐标请在末尾加上「高德」，百度地图加上「百度」。"
+
 // DatabaseErrorMessage 数据库错误信息
 const DatabaseErrorMessage string = "数据库错误，请联系开发者修 bug。开源地址：https://github.com/yukichan-bot-module/MiraiGo-module-weather"
 
 var instance *weather
 var logger = utils.GetModuleLogger("com.aimerneige.weather")
-var weatherConfig Config
 
 type weather struct {
 }
@@ -78,42 +126,63 @@ func (w *weather) MiraiGoModule() bot.ModuleInfo {
 // 在此处可以进行 Module 的初始化配置
 // 如配置读取
 func (w *weather) Init() {
-	path := config.GlobalConfig.GetString("aimerneige.weather.path")
-	if path == "" {
-		path = "./weather.yaml"
-	}
-	bytes := utils.ReadFile(path)
-	if err := yaml.Unmarshal(bytes, &weatherConfig); err != nil {
+	path := configPath()
+	c, bytes, problems, err := loadConfigFile(path)
+	if err != nil {
+		if strictConfig() {
+			logger.WithError(err).Fatalf("Unable to read config file in %s", path)
+		}
 		logger.WithError(err).Errorf("Unable to read config file in %s", path)
 	}
+	if len(problems) > 0 {
+		if strictConfig() {
+			logger.Fatalf("Found %d problems in config file %s, refuse to start in strict mode:\n%s", len(problems), path, strings.Join(problems, "\n"))
+		}
+		logger.Errorf("Found %d problems in config file %s:\n%s", len(problems), path, strings.Join(problems, "\n"))
+	}
+	setConfig(c)
+	configFile.hash = sha256.Sum256(bytes)
+	if err := configureTriggers(c.Commands.Prefix, c.Commands.Triggers); err != nil {
+		logger.WithError(err).Errorf("Invalid command triggers in %s, fallback to default triggers", path)
+	}
+	if c.Geocode.Path != "" {
+		g, err := geo.LoadGeocoder(c.Geocode.Path)
+		if err != nil {
+			logger.WithError(err).Errorf("Unable to load geocoder data in %s, fallback to embedded data", c.Geocode.Path)
+		} else {
+			geocoder.Store(g)
+		}
+	}
 }
 
 // PostInit 第二次初始化
 // 再次过程中可以进行跨 Module 的动作
 // 如通用数据库等等
 func (w *weather) PostInit() {
-	databaseType := weatherConfig.DB.Type
+	c := currentConfig()
+	databaseType := c.DB.Type
 	switch databaseType {
 	case "mysql":
 		mysqlDatabase := database.MysqlDatabase{
-			UserName: weatherConfig.DB.MySQL.Username,
-			Password: weatherConfig.DB.MySQL.Password,
-			Host:     weatherConfig.DB.MySQL.Host,
-			Port:     weatherConfig.DB.MySQL.Port,
-			Database: weatherConfig.DB.MySQL.Database,
-			CharSet:  weatherConfig.DB.MySQL.Charset,
+			UserName: c.DB.MySQL.Username,
+			Password: c.DB.MySQL.Password,
+			Host:     c.DB.MySQL.Host,
+			Port:     c.DB.MySQL.Port,
+			Database: c.DB.MySQL.Database,
+			CharSet:  c.DB.MySQL.Charset,
 		}
 		database.InitDatabase(mysqlDatabase)
 		logger.Info("Init mysql database success ", mysqlDatabase)
 	case "sqlite":
 		sqliteDatabase := database.SqliteDatabase{
-			FilePath: weatherConfig.DB.SQLite.Path,
+			FilePath: c.DB.SQLite.Path,
 		}
 		database.InitDatabase(sqliteDatabase)
 		logger.Info("Init sqlite database success ", sqliteDatabase)
 	default:
 		logger.Fatal("Unsupported database type: " + databaseType)
 	}
+	initAccessLists()
 }
 
 // Serve 注册服务函数部分
@@ -127,71 +196,65 @@ func (w *weather) Serve(b *bot.Bot) {
 		if inBlacklist(msg.Sender.Uin) {
 			return
 		}
-		replyMsgString := groupWeatherService(msg)
+		text, mentioned := messageText(c.Uin, msg.Elements)
+		// 仅响应 @ 机器人或回复机器人的消息
+		if !mentioned && isMentionOnlyGroup(msg.GroupCode) {
+			return
+		}
+		ctx := &commandContext{
+			scope:      scopeGroup,
+			sender:     msg.Sender,
+			groupCode:  msg.GroupCode,
+			groupAdmin: isGroupAdmin(c, msg.GroupCode, msg.Sender.Uin),
+		}
+		replyMsgString := dispatch(ctx, text)
 		if replyMsgString == "" {
 			return
 		}
-		replyMsg := message.NewSendingMessage().Append(message.NewText(replyMsgString))
-		c.SendGroupMessage(msg.GroupCode, replyMsg)
+		sent := c.SendGroupMessage(msg.GroupCode, groupReplyMessage(msg, ctx.repeatOf, replyMsgString))
+		if ctx.answerKey != "" {
+			rememberAnswer(ctx.answerKey, sent, time.Now())
+		}
 	})
 	b.PrivateMessageEvent.Subscribe(func(c *client.QQClient, msg *message.PrivateMessage) {
 		// 忽略黑名单用户
 		if inBlacklist(msg.Sender.Uin) {
 			return
 		}
-		replyMsgString := privateWeatherService(msg)
+		replyMsgString := dispatch(&commandContext{
+			scope:  scopePrivate,
+			sender: msg.Sender,
+		}, msg.ToString())
 		if replyMsgString == "" {
 			return
 		}
 		replyMsg := message.NewSendingMessage().Append(message.NewText(replyMsgString))
 		c.SendPrivateMessage(msg.Sender.Uin, replyMsg)
 	})
-	s := gocron.NewScheduler(time.UTC)
-	s.Every(1).Day().At("00:00").Do(func() {
-		dbService := service.NewDBService(database.GetDB())
-		err := dbService.ClearAllUserTimes()
-		if err != nil {
-			logger.WithError(err).Errorf("Fail to clear user times.")
-			for i := 0; i < 3 && err != nil; i++ {
-				err = dbService.ClearAllUserTimes()
-				logger.WithError(err).Errorf("Fail to clear user times. The %d times to retry.", i)
-			}
-			if err != nil {
-				// 重试三次依然错误，发送消息提示管理员
-				for _, admin := range weatherConfig.Admin {
-					msg := message.NewSendingMessage().Append(message.NewText("服务器又挂掉啦~\n错误信息：\n无法清空用户次数。"))
-					b.SendPrivateMessage(admin, msg)
-				}
-			}
+	b.GuildService.OnGuildChannelMessage(func(c *client.QQClient, msg *message.GuildChannelMessage) {
+		// 频道用户没有 QQ 号，使用 tiny id 作为用户标识
+		uin := int64(msg.Sender.TinyId)
+		if uin == int64(c.GuildService.TinyId) || inBlacklist(uin) {
+			return
+		}
+		text, _ := messageText(int64(c.GuildService.TinyId), msg.Elements)
+		replyMsgString := dispatch(&commandContext{
+			scope:     scopeGuild,
+			sender:    &message.Sender{Uin: uin, Nickname: msg.Sender.Nickname},
+			guildID:   msg.GuildId,
+			channelID: msg.ChannelId,
+		}, text)
+		if replyMsgString == "" {
+			return
+		}
+		if _, err := c.GuildService.SendGuildChannelMessage(msg.GuildId, msg.ChannelId, guildReplyMessage(msg, replyMsgString)); err != nil {
+			logger.WithError(err).Errorf("Fail to send guild channel message.")
 		}
 	})
-	for _, d := range weatherConfig.Daily {
-		_groupCode := d.GroupCode
-		_longitude := d.Longitude
-		_latitude := d.Latitude
-		_time := d.Time
-		_type := d.Type
-		_notify := d.Notify
-		s.Every(1).Day().At(_time).Do(func() {
-			caiyunAPI := service.NewCaiyun(weatherConfig.Key)
-			weatherString := ""
-			switch _type {
-			case "today":
-				weatherString, _ = caiyunAPI.Today(_longitude, _latitude)
-			case "tomorrow":
-				weatherString, _ = caiyunAPI.Tomorrow(_longitude, _latitude)
-			default:
-				weatherString = "配置文件错误，请检查"
-			}
-			if weatherString == "" {
-				return
-			}
-			replyMsgString := _notify + "\n" + weatherString
-			msg := message.NewSendingMessage().Append(message.NewText(replyMsgString))
-			b.SendGroupMessage(_groupCode, msg)
-		})
-	}
-	s.StartAsync()
+	serveBot = b
+	scheduleJobs(b)
+	resetScheduler.StartAsync()
+	dailyScheduler.StartAsync()
 }
 
 // Start 此函数会新开携程进行调用
@@ -203,6 +266,7 @@ func (w *weather) Serve(b *bot.Bot) {
 // 可以利用此部分进行后台操作
 // 如 http 服务器等等
 func (w *weather) Start(b *bot.Bot) {
+	go watchConfig()
 }
 
 // Stop 结束部分
@@ -212,99 +276,27 @@ func (w *weather) Start(b *bot.Bot) {
 func (w *weather) Stop(b *bot.Bot, wg *sync.WaitGroup) {
 	// 别忘了解锁
 	defer wg.Done()
+	stopWatchConfig()
 }
 
-// privateWeatherService 私聊服务
-func privateWeatherService(privateMsg *message.PrivateMessage) string {
-	sender := privateMsg.Sender
-	msg := privateMsg.ToString()
-	if strings.HasPrefix(msg, "修改地址 ") {
-		return updateLocation(sender, msg)
-	}
-	caiyunAPI := service.NewCaiyun(weatherConfig.Key)
-	switch msg {
-	case "实时天气":
-		return callWeatherAPI(sender.Uin, caiyunAPI.RealTime)
-	case "出门建议":
-		return callWeatherAPI(sender.Uin, caiyunAPI.Rain)
-	case "今天天气":
-		return callWeatherAPI(sender.Uin, caiyunAPI.Today)
-	case "明天天气":
-		return callWeatherAPI(sender.Uin, caiyunAPI.Tomorrow)
-	}
-	return ""
-}
-
-// groupWeatherService 群聊服务
-func groupWeatherService(groupMsg *message.GroupMessage) string {
-	sender := groupMsg.Sender
-	msg := groupMsg.ToString()
-	// 检查管理员指令
-	if strings.HasPrefix(msg, ".weather.") && isAdmin(sender.Uin) {
+// updateLocation 更新用户地址
+func updateLocation(sender *message.Sender, text string) string {
+	coordinate, err := geo.ParseCoordinate(text)
+	if err != nil {
+		var rangeErr *geo.RangeError
 		switch {
-		case strings.HasPrefix(msg, ".weather.clear.times "):
-			return clearUserTimes(msg)
-		case strings.HasPrefix(msg, ".weather.blacklist.add "):
-			return addUserToBlacklist(msg)
-		case strings.HasPrefix(msg, ".weather.blacklist.remove "):
-			return removeUserFromBlacklist(msg)
-		case strings.HasPrefix(msg, ".weather.whitelist.add "):
-			return addUserToWhitelist(msg)
-		case strings.HasPrefix(msg, ".weather.whitelist.remove "):
-			return removeUserFromWhitelist(msg)
-		case msg == ".weather.allowed":
-			return addGroupToAllowed(groupMsg.GroupCode)
-		case msg == ".weather.disallowed":
-			return removeGroupFromAllowed(groupMsg.GroupCode)
+		case errors.As(err, &rangeErr) && rangeErr.Latitude:
+			return fmt.Sprintf("解析失败，「%.4f」不是正确的纬度。", rangeErr.Value)
+		case errors.As(err, &rangeErr):
+			return fmt.Sprintf("解析失败，「%.4f」不是正确的经度。", rangeErr.Value)
+		case errors.Is(err, geo.ErrAxisConflict):
+			return "解析失败，两个数值被标记为同一方向，请检查经纬度标记。"
 		default:
-			return ""
+			return LocationFormatMessage
 		}
 	}
-	// 忽略未开启功能的群组
-	if !isAllowedGroup(groupMsg.GroupCode) {
-		return ""
-	}
-	// 解析用户指令
-	if strings.HasPrefix(msg, "修改地址 ") {
-		return updateLocation(sender, msg)
-	}
-	caiyunAPI := service.NewCaiyun(weatherConfig.Key)
-	switch msg {
-	case "实时天气":
-		return callWeatherAPI(sender.Uin, caiyunAPI.RealTime)
-	case "出门建议":
-		return callWeatherAPI(sender.Uin, caiyunAPI.Rain)
-	case "今天天气":
-		return callWeatherAPI(sender.Uin, caiyunAPI.Today)
-	case "明天天气":
-		return callWeatherAPI(sender.Uin, caiyunAPI.Tomorrow)
-	}
-	return ""
-}
-
-// updateLocation 更新用户地址
-func updateLocation(sender *message.Sender, msg string) string {
-	parts := strings.Split(msg, " ")
-	if len(parts) != 3 {
-		return "解析失败，请检查格式。正确的格式：「修改地址 经度 纬度」，示例：「修改地址 101.6656 39.2072」。"
-	}
-	var longitude float64
-	var latitude float64
-	var err error
-	longitude, err = strconv.ParseFloat(parts[1], 64)
-	if err != nil {
-		return fmt.Sprintf("解析失败，经度「%s」不是正确的数字。", parts[1])
-	}
-	latitude, err = strconv.ParseFloat(parts[2], 64)
-	if err != nil {
-		return fmt.Sprintf("解析失败，纬度「%s」不是正确的数字。", parts[2])
-	}
-	if longitude < -180.0 || longitude > 180.0 {
-		return fmt.Sprintf("解析失败，「%.4f」不是正确的经度。", longitude)
-	}
-	if latitude < -90.0 || latitude > 90.0 {
-		return fmt.Sprintf("解析失败，「%.4f」不是正确的纬度。", latitude)
-	}
+	coordinate = coordinate.WGS84()
+	longitude, latitude := coordinate.Longitude, coordinate.Latitude
 	dbService := service.NewDBService(database.GetDB())
 	_, err = dbService.GetUser(sender.Uin)
 	if err == gorm.ErrRecordNotFound {
@@ -312,7 +304,7 @@ func updateLocation(sender *message.Sender, msg string) string {
 			logger.WithError(err).Errorf("Fail to create user.")
 			return DatabaseErrorMessage
 		}
-		return "保存成功。"
+		return savedLocationMessage(longitude, latitude)
 	}
 	if err != nil {
 		logger.WithError(err).Errorf("Fail to get user.")
@@ -322,244 +314,288 @@ func updateLocation(sender *message.Sender, msg string) string {
 		logger.WithError(err).Errorf("Fail to update user.")
 		return DatabaseErrorMessage
 	}
-	return "保存成功。"
+	return savedLocationMessage(longitude, latitude)
+}
+
+// savedLocationMessage 保存地址成功的提示，附带解析出的地名
+func savedLocationMessage(longitude, latitude float64) string {
+	label := locationLabel(longitude, latitude)
+	if label == "" {
+		return fmt.Sprintf("保存成功。当前位置：%.4f, %.4f（未能识别地名，请确认经纬度是否正确）", longitude, latitude)
+	}
+	return fmt.Sprintf("保存成功。当前位置：%s（%.4f, %.4f）", label, longitude, latitude)
+}
+
+// locationLabel 逆地理编码得到的地名，无法识别时返回空字符串
+func locationLabel(longitude, latitude float64) string {
+	region, ok := currentGeocoder().Reverse(longitude, latitude)
+	if !ok {
+		return ""
+	}
+	return region.Label()
+}
+
+// replyTitle 回复的标题，如「北京市 海淀区 · 实时天气」
+func replyTitle(title string, longitude, latitude float64) string {
+	if label := locationLabel(longitude, latitude); label != "" {
+		return label + " · " + title
+	}
+	return title
 }
 
-func callWeatherAPI(uin int64, apiCalled func(float64, float64) (string, error)) string {
+// dailyTitle 未配置提示语时，定时推送使用的标题
+func dailyTitle(dailyType string, longitude, latitude float64) string {
+	switch dailyType {
+	case "today":
+		return replyTitle("今天天气", longitude, latitude)
+	case "tomorrow":
+		return replyTitle("明天天气", longitude, latitude)
+	}
+	return replyTitle("天气", longitude, latitude)
+}
+
+// updateTimezone 更新用户显示时区
+func updateTimezone(uin int64, name string) string {
+	switch name {
+	case "当地", "默认":
+		name = ""
+	case "北京时间":
+		name = "Asia/Shanghai"
+	}
+	if name != "" {
+		if _, err := time.LoadLocation(name); err != nil {
+			return fmt.Sprintf("解析失败，「%s」不是正确的时区。请使用 IANA 时区名称，如「设置时区 Asia/Shanghai」，或发送「设置时区 当地」使用预报地点的时区。", name)
+		}
+	}
 	dbService := service.NewDBService(database.GetDB())
-	longitude, latitude, err := dbService.GetUserLocation(uin)
+	if _, err := dbService.GetUser(uin); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return "未查询到地址信息，请先发送「修改地址 经度 纬度」保存地址。"
+		}
+		logger.WithError(err).Errorf("Fail to get user.")
+		return DatabaseErrorMessage
+	}
+	if err := dbService.UpdateUserTimezone(uin, name); err != nil {
+		logger.WithError(err).Errorf("Fail to update user timezone.")
+		return DatabaseErrorMessage
+	}
+	if name == "" {
+		return "设置成功，时间将按预报地点的时区显示。"
+	}
+	return fmt.Sprintf("设置成功，时间将按 %s 时区显示。", name)
+}
+
+// callWeatherAPI 查询用户所在位置的天气
+// apiCalled 为 service.Caiyun 的方法表达式，如 (*service.Caiyun).RealTime
+func callWeatherAPI(ctx *commandContext, title string, apiCalled func(*service.Caiyun, float64, float64) (string, error)) string {
+	return callWeatherAPIAt(ctx, title, nil, apiCalled)
+}
+
+// callWeatherAPIAt 查询天气，region 为空时查询用户保存的位置
+// 无论查询哪里都需要用户已保存地址，调用次数记在用户名下，次数上限和输出详细程度按群设置
+// 调用次数按指令的消耗计入用户和群或私聊的额度池，查询失败时退还，次数上限和查询频率按用户等级限制
+func callWeatherAPIAt(ctx *commandContext, title string, region *geo.Region, apiCalled func(*service.Caiyun, float64, float64) (string, error)) string {
+	uin := ctx.sender.Uin
+	dbService := service.NewDBService(database.GetDB())
+	user, err := dbService.GetUser(uin)
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return "未查询到地址信息，可通过群聊或私聊发送「修改地址 经度 纬度」来添加地址信息，经纬度信息需保留四位小数以上以保证精确度。示例：「修改地址 101.6656 39.2072」。发送后数据会被保存，如需修改使用同样的指令即可。\n\n注：不支持城市，因为城市准确度很差。本 bot 使用的 api 为付费 api，请勿滥用。"
+			return "未查询到地址信息，可通过群聊或私聊发送「修改地址 经度 纬度」来添加地址信息，经纬度信息需保留四位小数以上以保证精确度。示例：「修改地址 101.6656 39.2072」。也支持「纬度, 经度」和度分秒格式，高德、腾讯、百度地图的坐标请在末尾加上「高德」或「百度」。发送后数据会被保存，如需修改使用同样的指令即可。\n\n注：不支持城市，因为城市准确度很差。本 bot 使用的 api 为付费 api，请勿滥用。发送「天气帮助」查看全部指令。"
 		}
 		logger.WithError(err).Errorf("Fail to get user location.")
 		return DatabaseErrorMessage
 	}
-	if !inWhitelist(uin) {
-		times, err := dbService.GetUserTimes(uin)
-		if err != nil {
-			logger.WithError(err).Errorf("Fail to get user times.")
-			return DatabaseErrorMessage
+	longitude, latitude := user.Longitude, user.Latitude
+	if region != nil {
+		longitude, latitude = region.Longitude, region.Latitude
+	}
+	// 频率限制在去重之前检查，重复查询的提示同样受限，避免刷屏
+	_, tier := userTier(user)
+	if !tier.Unlimited {
+		if reply, ok := limiter.allow(ctx, tier, time.Now()); !ok {
+			return reply
 		}
-		if times >= weatherConfig.Limit {
-			return fmt.Sprintf("彩云天气为付费 api，万次 8 元，为防止滥用，当前每人每日使用次数上限为 %d 次。", weatherConfig.Limit)
+	}
+	// 群内刚回答过相同的查询时引用之前的回答，不消耗调用次数
+	answerKey := ""
+	if ctx.scope == scopeGroup {
+		answerKey = dedupeKey(ctx, title, longitude, latitude, user.Timezone)
+		if answer := findRecentAnswer(answerKey, time.Now()); answer != nil {
+			ctx.repeatOf = answer
+			return DedupeMessage
 		}
 	}
-	if err := dbService.IncreaseUserTimes(uin); err != nil {
-		logger.WithError(err).Errorf("Fail to increase user times.")
-		return DatabaseErrorMessage
+	r, reply := reserveQuota(ctx, tier)
+	if r == nil {
+		return reply
+	}
+	caiyunAPI := service.NewCaiyun(currentConfig().Key)
+	caiyunAPI.Verbosity = verbosity(ctx)
+	if user.Timezone != "" {
+		if loc, err := time.LoadLocation(user.Timezone); err == nil {
+			caiyunAPI.DisplayTimezone = loc
+		}
 	}
-	apiResponse, err := apiCalled(longitude, latitude)
+	apiResponse, err := apiCalled(caiyunAPI, longitude, latitude)
 	if err != nil {
+		r.refund()
 		return "调用天气 api 时发生错误。可能是网络问题或 api 使用次数耗尽。"
 	}
-	return apiResponse
+	ctx.answerKey = answerKey
+	if region != nil {
+		return region.Label() + " · " + title + "\n" + apiResponse
+	}
+	return replyTitle(title, longitude, latitude) + "\n" + apiResponse
 }
 
 // clearUserTimes 清除用户调用次数
-func clearUserTimes(msg string) string {
-	if len(msg) <= 21 {
-		return ""
-	}
-	uinString := msg[21:]
-	uin, err := strconv.ParseInt(uinString, 10, 64)
-	if err != nil {
-		return fmt.Sprintf("解析失败，「%s」不是正确的 uin。", uinString)
-	}
+func clearUserTimes(uins []int64) string {
 	dbService := service.NewDBService(database.GetDB())
-	err = dbService.ClearUserTimes(uin)
-	if err != nil {
-		logger.WithError(err).Errorf("Fail to clear user times.")
-		return "数据库错误，请检查后台日志。"
+	for _, uin := range uins {
+		if err := dbService.ClearUserTimes(uin); err != nil {
+			logger.WithError(err).Errorf("Fail to clear user times.")
+			return "数据库错误，请检查后台日志。"
+		}
 	}
-	return "清除用户调用次数成功。"
+	return fmt.Sprintf("成功清除用户%s的调用次数。", quoteIDs(uins))
 }
 
 // addUserToBlacklist 添加用户到黑名单
-func addUserToBlacklist(msg string) string {
-	if len(msg) <= 23 {
-		return ""
-	}
-	uinString := msg[23:]
-	uin, err := strconv.ParseInt(uinString, 10, 64)
-	if err != nil {
-		return fmt.Sprintf("解析失败，「%s」不是正确的 uin。", uinString)
-	}
-	if inBlacklist(uin) {
-		return "该用户已在黑名单中。"
-	}
-	weatherConfig.BlackList = append(weatherConfig.BlackList, uin)
-	err = updateWeatherConfigFile(weatherConfig)
-	if err != nil {
-		logger.WithError(err).Errorf("Fail to update config file.")
-		return "在更新配置文件时出现了错误，请查看后台日志。"
-	}
-	return fmt.Sprintf("成功添加用户「%d」到黑名单。", uin)
+func addUserToBlacklist(ctx *commandContext, uins []int64) string {
+	return updateAccessList(ctx, listBlacklist, uins, true, "用户", "黑名单")
 }
 
 // removeUserFromBlacklist 将用户从黑名单中移除
-func removeUserFromBlacklist(msg string) string {
-	if len(msg) <= 26 {
-		return ""
-	}
-	uinString := msg[26:]
-	uin, err := strconv.ParseInt(uinString, 10, 64)
-	if err != nil {
-		return fmt.Sprintf("解析失败，「%s」不是正确的 uin。", uinString)
-	}
-	if !inBlacklist(uin) {
-		return "该用户不在黑名单中。"
-	}
-	for i, v := range weatherConfig.BlackList {
-		if v == uin {
-			weatherConfig.BlackList = append(weatherConfig.BlackList[:i], weatherConfig.BlackList[i+1:]...)
-			break
-		}
-	}
-	err = updateWeatherConfigFile(weatherConfig)
-	if err != nil {
-		logger.WithError(err).Errorf("Fail to update config file.")
-		return "在更新配置文件时出现了错误，请查看后台日志。"
-	}
-	return fmt.Sprintf("成功将用户「%d」从黑名单中移除。", uin)
+func removeUserFromBlacklist(ctx *commandContext, uins []int64) string {
+	return updateAccessList(ctx, listBlacklist, uins, false, "用户", "黑名单")
 }
 
 // addUserToWhitelist 添加用户到白名单
-func addUserToWhitelist(msg string) string {
-	if len(msg) <= 23 {
-		return ""
-	}
-	uinString := msg[23:]
-	uin, err := strconv.ParseInt(uinString, 10, 64)
-	if err != nil {
-		return fmt.Sprintf("解析失败，「%s」不是正确的 uin。", uinString)
-	}
-	if inWhitelist(uin) {
-		return "该用户已在白名单中。"
-	}
-	weatherConfig.WhiteList = append(weatherConfig.WhiteList, uin)
-	err = updateWeatherConfigFile(weatherConfig)
-	if err != nil {
-		logger.WithError(err).Errorf("Fail to update config file.")
-		return "在更新配置文件时出现了错误，请查看后台日志。"
-	}
-	return fmt.Sprintf("成功添加用户「%d」到白名单。", uin)
+func addUserToWhitelist(ctx *commandContext, uins []int64) string {
+	return updateAccessList(ctx, listWhitelist, uins, true, "用户", "白名单")
 }
 
 // removeUserFromWhitelist 将用户从白名单中移除
-func removeUserFromWhitelist(msg string) string {
-	if len(msg) <= 26 {
-		return ""
-	}
-	uinString := msg[26:]
-	uin, err := strconv.ParseInt(uinString, 10, 64)
-	if err != nil {
-		return fmt.Sprintf("解析失败，「%s」不是正确的 uin。", uinString)
-	}
-	if !inWhitelist(uin) {
-		return "该用户不在白名单中。"
-	}
-	for i, v := range weatherConfig.WhiteList {
-		if v == uin {
-			weatherConfig.WhiteList = append(weatherConfig.WhiteList[:i], weatherConfig.WhiteList[i+1:]...)
-			break
-		}
-	}
-	err = updateWeatherConfigFile(weatherConfig)
-	if err != nil {
-		logger.WithError(err).Errorf("Fail to update config file.")
-		return "在更新配置文件时出现了错误，请查看后台日志。"
-	}
-	return fmt.Sprintf("成功将用户「%d」从白名单中移除。", uin)
+func removeUserFromWhitelist(ctx *commandContext, uins []int64) string {
+	return updateAccessList(ctx, listWhitelist, uins, false, "用户", "白名单")
 }
 
 // addGroupToAllowed 添加群组到许可名单
-func addGroupToAllowed(groupCode int64) string {
-	if isAllowedGroup(groupCode) {
-		return "本群已加入许可名单。"
-	}
-	weatherConfig.Allowed = append(weatherConfig.Allowed, groupCode)
-	err := updateWeatherConfigFile(weatherConfig)
-	if err != nil {
-		logger.WithError(err).Errorf("Fail to update config file.")
-		return "在更新配置文件时出现了错误，请查看后台日志。"
-	}
-	return fmt.Sprintf("成功添加群「%d」到许可名单。", groupCode)
+func addGroupToAllowed(ctx *commandContext, groupCodes []int64) string {
+	return updateAccessList(ctx, listAllowed, groupCodes, true, "群", "许可名单")
 }
 
 // removeGroupFromAllowed 将群组从许可名单中移除
-func removeGroupFromAllowed(groupCode int64) string {
-	if !isAllowedGroup(groupCode) {
-		return "本群不在许可名单中。"
-	}
-	for i, v := range weatherConfig.Allowed {
-		if v == groupCode {
-			weatherConfig.Allowed = append(weatherConfig.Allowed[:i], weatherConfig.Allowed[i+1:]...)
-			break
-		}
-	}
-	err := updateWeatherConfigFile(weatherConfig)
-	if err != nil {
-		logger.WithError(err).Errorf("Fail to update config file.")
-		return "在更新配置文件时出现了错误，请查看后台日志。"
-	}
-	return fmt.Sprintf("成功将群「%d」从许可名单中移除。", groupCode)
+func removeGroupFromAllowed(ctx *commandContext, groupCodes []int64) string {
+	return updateAccessList(ctx, listAllowed, groupCodes, false, "群", "许可名单")
 }
 
-func updateWeatherConfigFile(newConfig Config) error {
-	path := config.GlobalConfig.GetString("aimerneige.weather.path")
-	if path == "" {
-		path = "./weather.yaml"
-	}
-	data, err := yaml.Marshal(newConfig)
-	if err != nil {
-		return err
+// quoteIDs 号码列表，如「123」「456」
+func quoteIDs(ids []int64) string {
+	var b strings.Builder
+	for _, id := range ids {
+		fmt.Fprintf(&b, "「%d」", id)
 	}
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	_, err = f.Write(data)
-	if err != nil {
-		return err
-	}
-	weatherConfig = newConfig
-	return nil
+	return b.String()
 }
 
-func isAdmin(uin int64) bool {
-	for _, v := range weatherConfig.Admin {
-		if v == uin {
-			return true
+// groupReplyMessage 群聊回复，按配置引用触发的消息并 @ 发送者
+// repeatOf 不为空时为重复查询，总是引用之前的回答
+func groupReplyMessage(msg *message.GroupMessage, repeatOf *message.GroupMessage, text string) *message.SendingMessage {
+	quote, at := replyStyle(msg.GroupCode)
+	replyMsg := message.NewSendingMessage()
+	if repeatOf != nil {
+		replyMsg.Append(message.NewReply(repeatOf))
+	} else if quote {
+		replyMsg.Append(message.NewReply(msg))
+	}
+	if at {
+		replyMsg.Append(message.NewAt(msg.Sender.Uin, "@"+msg.Sender.DisplayName()))
+		text = "\n" + text
+	}
+	return replyMsg.Append(message.NewText(text))
+}
+
+// guildReplyMessage 频道回复，按默认配置 @ 发送者
+func guildReplyMessage(msg *message.GuildChannelMessage, text string) *message.SendingMessage {
+	replyMsg := message.NewSendingMessage()
+	if _, at := replyStyle(0); at {
+		mention := message.NewAt(int64(msg.Sender.TinyId), "@"+msg.Sender.Nickname)
+		mention.SubType = message.AtTypeGuildMember
+		replyMsg.Append(mention)
+		text = "\n" + text
+	}
+	return replyMsg.Append(message.NewText(text))
+}
+
+// replyStyle 群聊回复是否引用消息、是否 @ 发送者，群单独配置的优先
+func replyStyle(groupCode int64) (quote bool, at bool) {
+	c := currentConfig()
+	option, ok := c.replyGroups[groupCode]
+	if !ok {
+		option = c.reply
+	}
+	return option.quote, option.at
+}
+
+// messageText 消息中的指令文本，去掉 @ 机器人和回复的部分，@ 其他成员转换为「@QQ 号」
+// botID 为机器人的 QQ 号，频道中为机器人的 tiny id；mentioned 表示消息是否 @ 了机器人或者回复了机器人的消息
+func messageText(botID int64, elements []message.IMessageElement) (text string, mentioned bool) {
+	var filtered message.GroupMessage
+	for _, elem := range elements {
+		switch e := elem.(type) {
+		case *message.AtElement:
+			if e.Target == botID {
+				mentioned = true
+				continue
+			}
+			// @ 其他成员转换为「@QQ 号」，管理员指令可以直接 @ 目标用户
+			if e.Target != 0 {
+				filtered.Elements = append(filtered.Elements, message.NewText(fmt.Sprintf(" @%d ", e.Target)))
+				continue
+			}
+		case *message.ReplyElement:
+			if e.Sender == botID {
+				mentioned = true
+			}
+			continue
 		}
+		filtered.Elements = append(filtered.Elements, elem)
 	}
-	return false
+	return strings.TrimSpace(filtered.ToString()), mentioned
+}
+
+// isGroupAdmin 用户是否为群主或群管理员
+func isGroupAdmin(c *client.QQClient, groupCode, uin int64) bool {
+	group := c.FindGroup(groupCode)
+	if group == nil {
+		return false
+	}
+	member := group.FindMember(uin)
+	return member != nil && (member.Permission == client.Owner || member.Permission == client.Administrator)
+}
+
+func isAdmin(uin int64) bool {
+	return inList(listAdmins, uin)
 }
 
 func isAllowedGroup(id int64) bool {
-	for _, groupID := range weatherConfig.Allowed {
-		if id == groupID {
-			return true
-		}
-	}
-	return false
+	return inList(listAllowed, id)
 }
 
 func inBlacklist(userID int64) bool {
-	for _, v := range weatherConfig.BlackList {
-		if userID == v {
-			return true
-		}
-	}
-	return false
+	return inList(listBlacklist, userID)
 }
 
 func inWhitelist(userID int64) bool {
-	for _, v := range weatherConfig.WhiteList {
-		if userID == v {
-			return true
-		}
-	}
-	return false
+	return inList(listWhitelist, userID)
+}
+
+func isMentionOnlyGroup(groupCode int64) bool {
+	return currentConfig().mentionOnly[groupCode]
+}
+
+func isAllowedChannel(guildID, channelID uint64) bool {
+	channels := currentConfig().channels[guildID]
+	return channels[0] || channels[channelID]
 }